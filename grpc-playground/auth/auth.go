@@ -0,0 +1,131 @@
+// Package auth holds what the server and its clients share about bearer
+// tokens: HS256 JWTs and a grpc.PerRPCCredentials that sends a token with
+// every call.
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Roles, from least to most privileged. Each one includes the ones before it.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// RoleRank orders roles for comparisons; unknown roles rank 0.
+func RoleRank(role string) int {
+	switch role {
+	case RoleViewer:
+		return 1
+	case RoleEditor:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+	return 0
+}
+
+// Claims is the JWT payload the server understands.
+type Claims struct {
+	Subject   string `json:"sub"`
+	Role      string `json:"role"`
+	ExpiresAt int64  `json:"exp"`           // Unix seconds; required
+	NotBefore int64  `json:"nbf,omitempty"` // Unix seconds
+	IssuedAt  int64  `json:"iat,omitempty"` // Unix seconds
+}
+
+// MinSecretLen is the shortest HS256 secret accepted: the key should be at
+// least as long as the hash output.
+const MinSecretLen = 32
+
+// jwtHeader is the only header SignHS256 writes and VerifyHS256 accepts.
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// SignHS256 returns a compact JWT for c, signed with secret.
+func SignHS256(secret []byte, c Claims) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	signed := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac(secret, signed)), nil
+}
+
+// VerifyHS256 checks token's signature and time claims and returns its
+// claims. Only HS256 is accepted, whatever the header says, so a token
+// claiming "alg":"none" cannot skip the signature check.
+func VerifyHS256(secret []byte, token string, now time.Time) (Claims, error) {
+	var c Claims
+
+	headerPart, rest, ok1 := strings.Cut(token, ".")
+	payloadPart, sigPart, ok2 := strings.Cut(rest, ".")
+	if !ok1 || !ok2 {
+		return c, errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(headerPart)
+	if err != nil || json.Unmarshal(raw, &header) != nil {
+		return c, errors.New("malformed token header")
+	}
+	if header.Alg != "HS256" {
+		return c, fmt.Errorf("unsupported algorithm %q", header.Alg)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(sigPart)
+	if err != nil || !hmac.Equal(sig, mac(secret, headerPart+"."+payloadPart)) {
+		return c, errors.New("bad signature")
+	}
+
+	raw, err = base64.RawURLEncoding.DecodeString(payloadPart)
+	if err != nil || json.Unmarshal(raw, &c) != nil {
+		return c, errors.New("malformed token payload")
+	}
+
+	// Allow a little clock skew between whoever issued the token and us.
+	const leeway = 30 * time.Second
+	switch {
+	case c.ExpiresAt == 0:
+		return c, errors.New("token has no expiry")
+	case now.After(time.Unix(c.ExpiresAt, 0).Add(leeway)):
+		return c, errors.New("token has expired")
+	case c.NotBefore != 0 && now.Add(leeway).Before(time.Unix(c.NotBefore, 0)):
+		return c, errors.New("token is not valid yet")
+	}
+	return c, nil
+}
+
+func mac(secret []byte, signed string) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(signed))
+	return h.Sum(nil)
+}
+
+// BearerToken sends "authorization: Bearer <token>" with every RPC. Use it
+// with grpc.WithPerRPCCredentials.
+type BearerToken struct {
+	Token string
+	// AllowInsecure lets the token travel over a plaintext connection, as in
+	// this playground. Anyone on the network path can then read and replay it.
+	AllowInsecure bool
+}
+
+func (t BearerToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + t.Token}, nil
+}
+
+func (t BearerToken) RequireTransportSecurity() bool {
+	return !t.AllowInsecure
+}
@@ -0,0 +1,110 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// forge builds a token with any header, signed with secret.
+func forge(t *testing.T, secret []byte, header string, c Claims) string {
+	t.Helper()
+	payload, err := json.Marshal(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString([]byte(header)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac(secret, signed))
+}
+
+func TestVerifyHS256(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	valid := Claims{Subject: "ana", Role: RoleEditor, ExpiresAt: now.Add(time.Hour).Unix()}
+
+	token, err := SignHS256(testSecret, valid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := VerifyHS256(testSecret, token, now)
+	if err != nil {
+		t.Fatalf("valid token: %v", err)
+	}
+	if c != valid {
+		t.Errorf("claims = %+v, want %+v", c, valid)
+	}
+}
+
+func TestVerifyHS256Rejects(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	sign := func(c Claims) string {
+		token, err := SignHS256(testSecret, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := sign(Claims{Subject: "ana", Role: RoleEditor, ExpiresAt: now.Add(time.Hour).Unix()})
+	header, rest, _ := strings.Cut(valid, ".")
+	payload, _, _ := strings.Cut(rest, ".")
+
+	tests := []struct {
+		name, token, want string
+	}{
+		{"malformed", "abc", "malformed token"},
+		{"alg none", forge(t, testSecret, `{"alg":"none"}`, Claims{ExpiresAt: now.Unix()}), "unsupported algorithm"},
+		{"alg RS256", forge(t, testSecret, `{"alg":"RS256"}`, Claims{ExpiresAt: now.Unix()}), "unsupported algorithm"},
+		{"no signature", header + "." + payload + ".", "bad signature"},
+		{"other secret", forge(t, []byte("another secret, just as long as 32"), `{"alg":"HS256"}`, Claims{ExpiresAt: now.Unix()}), "bad signature"},
+		{"swapped payload", header + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"ana","role":"admin"}`)) + "." + strings.Split(valid, ".")[2], "bad signature"},
+		{"no exp", sign(Claims{Subject: "ana", Role: RoleViewer}), "no expiry"},
+		{"expired", sign(Claims{ExpiresAt: now.Add(-time.Minute).Unix()}), "expired"},
+		{"not yet valid", sign(Claims{ExpiresAt: now.Add(time.Hour).Unix(), NotBefore: now.Add(time.Minute).Unix()}), "not valid yet"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := VerifyHS256(testSecret, tc.token, now)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("error %v, want one containing %q", err, tc.want)
+			}
+		})
+	}
+}
+
+// Up to 30 seconds of clock skew is tolerated on either side.
+func TestVerifyHS256Leeway(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	tests := []struct {
+		name   string
+		claims Claims
+		ok     bool
+	}{
+		{"expired 20s ago", Claims{ExpiresAt: now.Add(-20 * time.Second).Unix()}, true},
+		{"expired 40s ago", Claims{ExpiresAt: now.Add(-40 * time.Second).Unix()}, false},
+		{"valid in 20s", Claims{ExpiresAt: now.Add(time.Hour).Unix(), NotBefore: now.Add(20 * time.Second).Unix()}, true},
+		{"valid in 40s", Claims{ExpiresAt: now.Add(time.Hour).Unix(), NotBefore: now.Add(40 * time.Second).Unix()}, false},
+	}
+	for _, tc := range tests {
+		token, err := SignHS256(testSecret, tc.claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := VerifyHS256(testSecret, token, now); (err == nil) != tc.ok {
+			t.Errorf("%s: error %v, want ok=%v", tc.name, err, tc.ok)
+		}
+	}
+}
+
+func TestRoleRank(t *testing.T) {
+	if !(RoleRank(RoleViewer) < RoleRank(RoleEditor) && RoleRank(RoleEditor) < RoleRank(RoleAdmin)) {
+		t.Error("roles are not ordered viewer < editor < admin")
+	}
+	for _, role := range []string{"", "root", "Admin"} {
+		if RoleRank(role) != 0 {
+			t.Errorf("RoleRank(%q) = %d, want 0", role, RoleRank(role))
+		}
+	}
+}
@@ -1,3 +1,4 @@
 #!/usr/bin/env bash
 
-go run ./client
+# e.g. ./boot-client.sh -token dev-admin-key, or -watch -snapshot in another terminal
+go run ./client "$@"
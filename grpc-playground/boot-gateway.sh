@@ -0,0 +1,4 @@
+#!/usr/bin/env bash
+
+# e.g. ./boot-gateway.sh -addr :8080 -grpc-addr localhost:50051
+go run ./gateway/cmd/gateway "$@"
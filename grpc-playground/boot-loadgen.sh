@@ -0,0 +1,4 @@
+#!/usr/bin/env bash
+
+# e.g. ./boot-loadgen.sh -target rest -rate 200 -duration 30s
+go run ./loadgen "$@"
@@ -1,3 +1,4 @@
 #!/usr/bin/env bash
 
-go run ./server
+# e.g. ./boot-server.sh -auth-key-file auth-keys.example -metrics-addr :9090 -http-addr :8080
+go run ./server "$@"
@@ -2,13 +2,25 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
 	"time"
 
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 
+	"github.com/cristianmanoliu/learning-golang/grpc-playground/auth"
 	userpb "github.com/cristianmanoliu/learning-golang/grpc-playground/proto_gen/proto"
 )
 
@@ -16,12 +28,28 @@ func main() {
 	// Add timestamps + file:line to logs
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	// Dial the gRPC server running on localhost:50051
-	conn, err := grpc.Dial(
-		"localhost:50051",
+	watch := flag.Bool("watch", false, "stream user changes until Ctrl-C instead of running the demo calls")
+	snapshot := flag.Bool("snapshot", false, "with -watch, start with the existing users")
+	resume := flag.String("resume", "", "with -watch, resume after the event that printed this token")
+	token := flag.String("token", "", "bearer token to send: a static key or a JWT")
+	jwtSecretFile := flag.String("jwt-secret-file", "", "mint a JWT with the server's secret instead of passing -token")
+	role := flag.String("role", auth.RoleAdmin, "with -jwt-secret-file, the role to put in the token")
+	flag.Parse()
+
+	dialOpts := []grpc.DialOption{
 		grpc.WithTransportCredentials(insecure.NewCredentials()),
 		grpc.WithBlock(),
-	)
+	}
+	if *jwtSecretFile != "" {
+		*token = mintToken(*jwtSecretFile, *role)
+	}
+	if *token != "" {
+		// Attached to every call on the connection, unary and streaming.
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(auth.BearerToken{Token: *token, AllowInsecure: true}))
+	}
+
+	// Dial the gRPC server running on localhost:50051
+	conn, err := grpc.Dial("localhost:50051", dialOpts...)
 	if err != nil {
 		log.Fatalf("dial: %v", err)
 	}
@@ -30,31 +58,257 @@ func main() {
 	// Create a typed client for UserService
 	client := userpb.NewUserServiceClient(conn)
 
-	// Use a context with timeout for both calls
+	if *watch {
+		watchUsers(client, &userpb.WatchUsersRequest{SendSnapshot: *snapshot, ResumeToken: *resume})
+		return
+	}
+
+	// Use a context with timeout for all calls
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// 1) Call CreateUser
-	createResp, err := client.CreateUser(ctx, &userpb.CreateUserRequest{
-		Name: "Cristi",
-	})
+	// 1) Call CreateUser, tagged with our own request ID; the server logs it
+	// and echoes it back in the response headers
+	var header metadata.MD
+	createResp, err := client.CreateUser(
+		metadata.AppendToOutgoingContext(ctx, "x-request-id", fmt.Sprintf("client-%d", time.Now().UnixNano())),
+		&userpb.CreateUserRequest{Name: "Cristi"},
+		grpc.Header(&header),
+	)
 	if err != nil {
 		log.Fatalf("CreateUser: %v", err)
 	}
 
-	fmt.Printf("Created user: id=%s name=%s\n",
+	fmt.Printf("Created user: id=%s name=%s request_id=%s\n",
 		createResp.GetUser().GetId(),
 		createResp.GetUser().GetName(),
+		header.Get("x-request-id"),
 	)
 
-	// 2) Call ListUsers
-	listResp, err := client.ListUsers(ctx, &userpb.ListUsersRequest{})
+	// 2) Call ListUsers, following next_page_token until the last page
+	fmt.Println("Users by name:")
+	listReq := &userpb.ListUsersRequest{
+		PageSize: 2,
+		OrderBy:  "name",
+		// Filter: `name:"Cri*"` would keep only matching names
+	}
+	for page := 1; ; page++ {
+		listResp, err := client.ListUsers(ctx, listReq)
+		if err != nil {
+			log.Fatalf("ListUsers: %v", err)
+		}
+		for _, u := range listResp.GetUsers() {
+			fmt.Printf("  page %d: id=%s name=%s\n", page, u.GetId(), u.GetName())
+		}
+		// Stop after a few pages; the server keeps every user from every run.
+		if listResp.GetNextPageToken() == "" || page == 3 {
+			break
+		}
+		listReq.PageToken = listResp.GetNextPageToken()
+	}
+
+	_, err = client.ListUsers(ctx, &userpb.ListUsersRequest{PageToken: "not-a-token"})
+	printStatus("ListUsers with a made-up token", err)
+
+	id := createResp.GetUser().GetId()
+
+	// 3) Call GetUser
+	getResp, err := client.GetUser(ctx, &userpb.GetUserRequest{Id: id})
+	if err != nil {
+		log.Fatalf("GetUser: %v", err)
+	}
+	fmt.Printf("Got user: id=%s name=%s\n", getResp.GetUser().GetId(), getResp.GetUser().GetName())
+
+	// 4) Call UpdateUser, changing only the fields named in the mask
+	updateResp, err := client.UpdateUser(ctx, &userpb.UpdateUserRequest{
+		User:       &userpb.User{Id: id, Name: "Cristian"},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name"}},
+	})
 	if err != nil {
-		log.Fatalf("ListUsers: %v", err)
+		log.Fatalf("UpdateUser: %v", err)
 	}
+	fmt.Printf("Updated user: id=%s name=%s version=%d\n",
+		updateResp.GetUser().GetId(),
+		updateResp.GetUser().GetName(),
+		updateResp.GetUser().GetVersion(),
+	)
+
+	// 5) Call UpdateUser with an empty name and a mask path User doesn't
+	// have: the error carries one violation per field, which a UI could show
+	// next to its inputs
+	_, err = client.UpdateUser(ctx, &userpb.UpdateUserRequest{
+		User:       &userpb.User{Id: id},
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"name", "email"}},
+	})
+	printStatus("UpdateUser with bad input", err)
+
+	// 6) Call DeleteUser with the version we read before the update: the
+	// server refuses because the user changed since
+	_, err = client.DeleteUser(ctx, &userpb.DeleteUserRequest{Id: id, Version: getResp.GetUser().GetVersion()})
+	printStatus("DeleteUser with stale version", err)
+
+	// 7) Call DeleteUser with the current version, then show that the user is gone
+	if _, err := client.DeleteUser(ctx, &userpb.DeleteUserRequest{Id: id, Version: updateResp.GetUser().GetVersion()}); err != nil {
+		log.Fatalf("DeleteUser: %v", err)
+	}
+	fmt.Printf("Deleted user: id=%s\n", id)
+
+	_, err = client.GetUser(ctx, &userpb.GetUserRequest{Id: id})
+	if status.Code(err) != codes.NotFound {
+		log.Fatalf("GetUser after delete: want NotFound, got %v", err)
+	}
+	printStatus("GetUser after delete", err)
+
+	// 8) Call BulkCreateUsers: stream several requests, get one summary
+	bulkCreate(ctx, client, []string{"Ana", "", "Bob"})
+
+	// 9) Call SyncUsers: every item is acknowledged on its own, and resending
+	// an item after a reconnect does not create it twice
+	batchID := fmt.Sprintf("demo-%d", time.Now().UnixNano())
+	syncUsers(ctx, client, batchID, 1, []string{"Dan", "Eve"})
+	syncUsers(ctx, client, batchID, 2, []string{"Eve", "Fay"}) // Eve again, as after a lost ack
+}
+
+// mintToken signs a one-hour JWT for the client itself, as a login service
+// would in a real setup.
+func mintToken(secretFile, role string) string {
+	secret, err := os.ReadFile(secretFile)
+	if err != nil {
+		log.Fatalf("jwt secret: %v", err)
+	}
+	now := time.Now()
+	token, err := auth.SignHS256([]byte(strings.TrimSpace(string(secret))), auth.Claims{
+		Subject:   "grpc-playground-client",
+		Role:      role,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		log.Fatalf("jwt: %v", err)
+	}
+	return token
+}
+
+func bulkCreate(ctx context.Context, client userpb.UserServiceClient, names []string) {
+	stream, err := client.BulkCreateUsers(ctx)
+	if err != nil {
+		log.Fatalf("BulkCreateUsers: %v", err)
+	}
+	for _, name := range names {
+		if err := stream.Send(&userpb.CreateUserRequest{Name: name}); err != nil {
+			log.Fatalf("BulkCreateUsers send: %v", err)
+		}
+	}
+	resp, err := stream.CloseAndRecv()
+	if err != nil {
+		log.Fatalf("BulkCreateUsers: %v", err)
+	}
+	fmt.Printf("BulkCreateUsers: created=%d failed=%d\n", resp.GetCreatedCount(), resp.GetFailedCount())
+	for _, f := range resp.GetFailures() {
+		printStatus(fmt.Sprintf("  item %d", f.GetIndex()), status.ErrorProto(f.GetStatus()))
+	}
+}
+
+// syncUsers sends names as items firstSeq, firstSeq+1, ... of batchID and
+// prints each acknowledgement.
+func syncUsers(ctx context.Context, client userpb.UserServiceClient, batchID string, firstSeq int64, names []string) {
+	stream, err := client.SyncUsers(ctx)
+	if err != nil {
+		log.Fatalf("SyncUsers: %v", err)
+	}
+
+	// Read acks while sending, as a real client streaming a large batch
+	// would; it keeps every item until its ack arrives.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			ack, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			if err != nil {
+				printStatus("SyncUsers", err)
+				return
+			}
+			switch {
+			case ack.GetDuplicate() && ack.GetUser() != nil:
+				fmt.Printf("SyncUsers: seq=%d already created id=%s name=%s\n",
+					ack.GetSequence(), ack.GetUser().GetId(), ack.GetUser().GetName())
+			case ack.GetDuplicate() && ack.GetError() == nil:
+				fmt.Printf("SyncUsers: seq=%d already done\n", ack.GetSequence())
+			case ack.GetError() != nil:
+				printStatus(fmt.Sprintf("SyncUsers: seq=%d", ack.GetSequence()), status.ErrorProto(ack.GetError()))
+			default:
+				fmt.Printf("SyncUsers: seq=%d created id=%s name=%s\n",
+					ack.GetSequence(), ack.GetUser().GetId(), ack.GetUser().GetName())
+			}
+		}
+	}()
+
+	for i, name := range names {
+		err := stream.Send(&userpb.SyncUsersRequest{
+			BatchId:  batchID,
+			Sequence: firstSeq + int64(i),
+			User:     &userpb.CreateUserRequest{Name: name},
+		})
+		if err != nil {
+			log.Fatalf("SyncUsers send: %v", err)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		log.Fatalf("SyncUsers: %v", err)
+	}
+	<-done
+}
+
+// watchUsers prints user events as they arrive. Run it in one terminal and
+// the demo in another to see them; restart it with -resume and the last token
+// to get what happened in between.
+func watchUsers(client userpb.UserServiceClient, req *userpb.WatchUsersRequest) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	stream, err := client.WatchUsers(ctx, req)
+	if err != nil {
+		log.Fatalf("WatchUsers: %v", err)
+	}
+	for {
+		ev, err := stream.Recv()
+		if errors.Is(err, io.EOF) || status.Code(err) == codes.Canceled {
+			return
+		}
+		if err != nil {
+			printStatus("WatchUsers", err)
+			return
+		}
+		fmt.Printf("%-17s id=%s name=%s version=%d token=%s\n",
+			ev.GetType(),
+			ev.GetUser().GetId(),
+			ev.GetUser().GetName(),
+			ev.GetUser().GetVersion(),
+			ev.GetResumeToken(),
+		)
+	}
+}
 
-	fmt.Println("Users from server:")
-	for _, u := range listResp.GetUsers() {
-		fmt.Printf("  id=%s name=%s\n", u.GetId(), u.GetName())
+// printStatus shows the code of a failed call and the details the server
+// attached to it.
+func printStatus(what string, err error) {
+	st, _ := status.FromError(err)
+	fmt.Printf("%s: %s: %s\n", what, st.Code(), st.Message())
+	for _, d := range st.Details() {
+		switch d := d.(type) {
+		case *errdetails.BadRequest:
+			for _, v := range d.GetFieldViolations() {
+				fmt.Printf("  field %s: %s\n", v.GetField(), v.GetDescription())
+			}
+		case *errdetails.PreconditionFailure:
+			for _, v := range d.GetViolations() {
+				fmt.Printf("  precondition %s on %s: %s\n", v.GetType(), v.GetSubject(), v.GetDescription())
+			}
+		case *errdetails.ResourceInfo:
+			fmt.Printf("  resource %s %s\n", d.GetResourceType(), d.GetResourceName())
+		}
 	}
 }
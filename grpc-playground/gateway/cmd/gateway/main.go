@@ -0,0 +1,61 @@
+// Command gateway serves UserService as HTTP/JSON in front of a separate
+// gRPC server. The server can also do this itself, see its -http-addr flag.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/cristianmanoliu/learning-golang/grpc-playground/gateway"
+)
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	addr := flag.String("addr", ":8080", "address to serve HTTP/JSON on")
+	grpcAddr := flag.String("grpc-addr", "localhost:50051", "UserService gRPC server to forward to")
+	flag.Parse()
+
+	conn, err := grpc.NewClient(*grpcAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		log.Fatalf("grpc client: %v", err)
+	}
+	defer conn.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	handler, err := gateway.NewHandler(ctx, conn)
+	if err != nil {
+		log.Fatalf("gateway: %v", err)
+	}
+	srv := &http.Server{Addr: *addr, Handler: handler}
+
+	go func() {
+		log.Printf("HTTP gateway listening on %s, forwarding to %s\n", *addr, *grpcAddr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("ListenAndServe: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+
+	// Watch streams do not end on their own; Close cuts off what is left.
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		_ = srv.Close()
+	}
+	log.Println("gateway stopped")
+}
@@ -0,0 +1,128 @@
+// Package gateway serves UserService as HTTP/JSON, using the routes in the
+// google.api.http options of user.proto. Every HTTP request becomes a gRPC
+// call over conn, so it goes through the same interceptors, auth included,
+// as any other client.
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/grpc-ecosystem/grpc-gateway/v2/runtime"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	userpb "github.com/cristianmanoliu/learning-golang/grpc-playground/proto_gen/proto"
+)
+
+// requestIDHeader is forwarded in both directions, so the server's log lines
+// and the HTTP response carry the same ID.
+const requestIDHeader = "x-request-id"
+
+// NewHandler returns the HTTP handler for UserService, e.g.
+//
+//	curl -X POST localhost:8080/v1/users -d '{"name": "Ana"}'
+//	curl 'localhost:8080/v1/users?pageSize=10&orderBy=name'
+//
+// The Authorization header is passed on as gRPC metadata.
+func NewHandler(ctx context.Context, conn *grpc.ClientConn) (http.Handler, error) {
+	mux := runtime.NewServeMux(
+		runtime.WithIncomingHeaderMatcher(incomingHeader),
+		runtime.WithOutgoingHeaderMatcher(outgoingHeader),
+		runtime.WithErrorHandler(writeError),
+	)
+	if err := userpb.RegisterUserServiceHandler(ctx, mux, conn); err != nil {
+		return nil, err
+	}
+	return mux, nil
+}
+
+// incomingHeader picks the HTTP headers that become gRPC metadata: the
+// defaults (Authorization, Grpc-Metadata-*, ...) and X-Request-Id.
+func incomingHeader(key string) (string, bool) {
+	if strings.EqualFold(key, requestIDHeader) {
+		return requestIDHeader, true
+	}
+	return runtime.DefaultHeaderMatcher(key)
+}
+
+// outgoingHeader picks the gRPC response headers that become HTTP headers.
+// Only the request ID is of any use to HTTP clients.
+func outgoingHeader(key string) (string, bool) {
+	if key == requestIDHeader {
+		return "X-Request-Id", true
+	}
+	return "", false
+}
+
+// HTTPStatus is the HTTP status code for a gRPC status code. It follows the
+// mapping in google/rpc/code.proto, except that FailedPrecondition, which
+// UserService only returns for a stale version, is 412 as for a failed
+// If-Match.
+func HTTPStatus(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // Client Closed Request, as nginx calls it
+	case codes.InvalidArgument, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.FailedPrecondition:
+		return http.StatusPreconditionFailed
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default: // Unknown, Internal, DataLoss
+		return http.StatusInternalServerError
+	}
+}
+
+// writeError sends err as a google.rpc.Status in JSON, details included, so
+// HTTP clients see the same field violations as gRPC clients.
+func writeError(ctx context.Context, _ *runtime.ServeMux, m runtime.Marshaler, w http.ResponseWriter, _ *http.Request, err error) {
+	st := status.Convert(err)
+	code := HTTPStatus(st.Code())
+
+	// Errors from the gateway itself, e.g. an unknown route or a body that is
+	// not JSON, come with their own HTTP status.
+	var he *runtime.HTTPStatusError
+	if errors.As(err, &he) {
+		st = status.Convert(he.Err)
+		code = he.HTTPStatus
+	}
+
+	body, merr := m.Marshal(st.Proto())
+	if merr != nil {
+		st = status.New(codes.Internal, "failed to marshal error")
+		code = http.StatusInternalServerError
+		body, _ = m.Marshal(st.Proto())
+	}
+
+	if md, ok := runtime.ServerMetadataFromContext(ctx); ok {
+		if ids := md.HeaderMD.Get(requestIDHeader); len(ids) > 0 {
+			w.Header().Set("X-Request-Id", ids[0])
+		}
+	}
+	if st.Code() == codes.Unauthenticated {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	w.Header().Set("Content-Type", m.ContentType(st.Proto()))
+	w.WriteHeader(code)
+	_, _ = w.Write(body)
+}
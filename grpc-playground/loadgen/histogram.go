@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+	"time"
+)
+
+// subBucketBits sets the precision: every power-of-two range is split into
+// 2^subBucketBits/2 linear buckets, so a recorded value is off by at most
+// 1/64 (about 1.6%) no matter how large it is.
+const (
+	subBucketBits  = 7
+	subBucketCount = 1 << subBucketBits // values below this are recorded exactly
+	subBucketHalf  = subBucketCount / 2
+)
+
+// histogram is a log-linear latency histogram in the spirit of HdrHistogram.
+// Recording is O(1) and the memory stays small (a few thousand counters for
+// microseconds up to hours), so every single request can be recorded instead
+// of sampling. Values are microseconds.
+type histogram struct {
+	counts []uint64
+	total  uint64
+	sum    float64
+	min    int64
+	max    int64
+}
+
+func newHistogram() *histogram {
+	return &histogram{min: math.MaxInt64}
+}
+
+// bucketIndex maps a value to its bucket.
+func bucketIndex(v int64) int {
+	if v < subBucketCount {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - subBucketBits // >= 1
+	top := int(v >> shift)                         // in [subBucketHalf, subBucketCount)
+	return subBucketCount + (shift-1)*subBucketHalf + (top - subBucketHalf)
+}
+
+// bucketUpper returns the largest value that lands in bucket i. Percentiles
+// report this, like HdrHistogram's highestEquivalentValue, so they never
+// understate latency.
+func bucketUpper(i int) int64 {
+	if i < subBucketCount {
+		return int64(i)
+	}
+	shift := (i-subBucketCount)/subBucketHalf + 1
+	top := int64((i-subBucketCount)%subBucketHalf + subBucketHalf)
+	return (top+1)<<shift - 1
+}
+
+func (h *histogram) record(d time.Duration) {
+	v := max(d.Microseconds(), 0)
+	i := bucketIndex(v)
+	if i >= len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, i+1-len(h.counts))...)
+	}
+	h.counts[i]++
+	h.total++
+	h.sum += float64(v)
+	h.min = min(h.min, v)
+	h.max = max(h.max, v)
+}
+
+// merge adds other's counts to h.
+func (h *histogram) merge(other *histogram) {
+	if other.total == 0 {
+		return
+	}
+	if len(other.counts) > len(h.counts) {
+		h.counts = append(h.counts, make([]uint64, len(other.counts)-len(h.counts))...)
+	}
+	for i, c := range other.counts {
+		h.counts[i] += c
+	}
+	h.total += other.total
+	h.sum += other.sum
+	h.min = min(h.min, other.min)
+	h.max = max(h.max, other.max)
+}
+
+// percentile returns the value at or below which p percent of the recorded
+// values fall.
+func (h *histogram) percentile(p float64) int64 {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(p / 100 * float64(h.total)))
+	rank = max(rank, 1)
+	var seen uint64
+	for i, c := range h.counts {
+		seen += c
+		if seen >= rank {
+			return min(bucketUpper(i), h.max)
+		}
+	}
+	return h.max
+}
+
+// latencySummary is the JSON form of a histogram, in milliseconds.
+type latencySummary struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	P999 float64 `json:"p999"`
+	Max  float64 `json:"max"`
+}
+
+func (h *histogram) summary() latencySummary {
+	if h.total == 0 {
+		return latencySummary{}
+	}
+	ms := func(us int64) float64 { return float64(us) / 1000 }
+	return latencySummary{
+		Min:  ms(h.min),
+		Mean: h.sum / float64(h.total) / 1000,
+		P50:  ms(h.percentile(50)),
+		P90:  ms(h.percentile(90)),
+		P99:  ms(h.percentile(99)),
+		P999: ms(h.percentile(99.9)),
+		Max:  ms(h.max),
+	}
+}
@@ -0,0 +1,423 @@
+// Command loadgen drives a mix of create, list and get requests against the
+// REST (rest-playground) or gRPC (this module's server) user service and
+// prints a JSON report with throughput, error rates and latency percentiles.
+//
+//	go run ./loadgen -target grpc -concurrency 50 -duration 30s
+//	go run ./loadgen -target rest -rate 500 -duration 1m -out rest-500rps.json
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var operations = []string{"create", "list", "get"}
+
+// opStats collects the outcome of one operation type on one worker.
+type opStats struct {
+	requests uint64
+	errors   map[string]uint64 // by error kind, e.g. "http 503"
+	latency  *histogram
+}
+
+func newOpStats() *opStats {
+	return &opStats{errors: make(map[string]uint64), latency: newHistogram()}
+}
+
+func (s *opStats) merge(other *opStats) {
+	s.requests += other.requests
+	for kind, n := range other.errors {
+		s.errors[kind] += n
+	}
+	s.latency.merge(other.latency)
+}
+
+func (s *opStats) errorCount() uint64 {
+	var n uint64
+	for _, c := range s.errors {
+		n += c
+	}
+	return n
+}
+
+// config is everything the flags control.
+type config struct {
+	target      string
+	addr        string
+	mix         map[string]int
+	rate        float64
+	concurrency int
+	duration    time.Duration
+	timeout     time.Duration
+	seedUsers   int
+}
+
+// report is the JSON printed at the end. Keep field names stable: reports of
+// different runs get diffed against each other.
+type report struct {
+	Target        string              `json:"target"`
+	Addr          string              `json:"addr"`
+	Mode          string              `json:"mode"` // "rate" or "concurrency"
+	TargetRate    float64             `json:"target_rate,omitempty"`
+	Concurrency   int                 `json:"concurrency"`
+	Mix           map[string]int      `json:"mix"`
+	StartedAt     time.Time           `json:"started_at"`
+	DurationSec   float64             `json:"duration_sec"`
+	Requests      uint64              `json:"requests"`
+	Errors        uint64              `json:"errors"`
+	ErrorRate     float64             `json:"error_rate"`
+	ThroughputRPS float64             `json:"throughput_rps"`
+	LatencyMS     latencySummary      `json:"latency_ms"`
+	Operations    map[string]opReport `json:"operations"`
+	ErrorKinds    map[string]uint64   `json:"error_kinds,omitempty"`
+	Interrupted   bool                `json:"interrupted,omitempty"`
+}
+
+type opReport struct {
+	Requests      uint64            `json:"requests"`
+	Errors        uint64            `json:"errors"`
+	ErrorRate     float64           `json:"error_rate"`
+	ThroughputRPS float64           `json:"throughput_rps"`
+	LatencyMS     latencySummary    `json:"latency_ms"`
+	ErrorKinds    map[string]uint64 `json:"error_kinds,omitempty"`
+}
+
+func main() {
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+
+	targetName := flag.String("target", "grpc", "service to load: rest or grpc")
+	addr := flag.String("addr", "", "service address (default http://localhost:8080 for rest, localhost:50051 for grpc)")
+	mix := flag.String("mix", "create=1,list=1,get=8", "relative weights of the operations")
+	rate := flag.Float64("rate", 0, "fixed request rate per second; 0 runs -concurrency workers as fast as they can")
+	concurrency := flag.Int("concurrency", 10, "number of workers (in -rate mode, the most requests in flight)")
+	duration := flag.Duration("duration", 30*time.Second, "how long to run")
+	timeout := flag.Duration("timeout", 5*time.Second, "timeout for each request")
+	seedUsers := flag.Int("seed-users", 10, "users to create before the run, so get has something to fetch")
+	listLimit := flag.Int("list-limit", 20, "page size for list requests")
+	tenant := flag.String("tenant", "", "X-Tenant-ID for REST requests")
+	token := flag.String("token", "", "bearer token for gRPC requests (editor role or above)")
+	out := flag.String("out", "", "file to write the JSON report to (default stdout)")
+	flag.Parse()
+
+	cfg := config{
+		target:      *targetName,
+		addr:        *addr,
+		rate:        *rate,
+		concurrency: *concurrency,
+		duration:    *duration,
+		timeout:     *timeout,
+		seedUsers:   *seedUsers,
+	}
+	var err error
+	if cfg.mix, err = parseMix(*mix); err != nil {
+		log.Fatalf("-mix: %v", err)
+	}
+	if cfg.concurrency < 1 || cfg.duration <= 0 || cfg.rate < 0 {
+		log.Fatal("-concurrency and -duration must be positive, -rate must not be negative")
+	}
+
+	var t target
+	switch cfg.target {
+	case "rest":
+		if cfg.addr == "" {
+			cfg.addr = "http://localhost:8080"
+		}
+		t = newRESTTarget(cfg.addr, *tenant, *listLimit, cfg.concurrency)
+	case "grpc":
+		if cfg.addr == "" {
+			cfg.addr = "localhost:50051"
+		}
+		if t, err = newGRPCTarget(cfg.addr, *listLimit, *token); err != nil {
+			log.Fatalf("grpc: %v", err)
+		}
+	default:
+		log.Fatalf("unknown -target %q", cfg.target)
+	}
+	defer t.close()
+
+	// Ctrl-C ends the run early but still prints the report.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	rep, err := run(ctx, t, cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	data = append(data, '\n')
+	if *out == "" {
+		_, _ = os.Stdout.Write(data)
+		return
+	}
+	if err := os.WriteFile(*out, data, 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%.0f req/s, %.2f%% errors, p99 %.2fms; report written to %s",
+		rep.ThroughputRPS, rep.ErrorRate*100, rep.LatencyMS.P99, *out)
+}
+
+// parseMix parses "create=1,list=1,get=8".
+func parseMix(s string) (map[string]int, error) {
+	mix := make(map[string]int)
+	total := 0
+	for _, part := range strings.Split(s, ",") {
+		op, weight, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok || !slices.Contains(operations, op) {
+			return nil, fmt.Errorf("%q: want op=weight with op one of %v", part, operations)
+		}
+		w, err := strconv.Atoi(weight)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("%q: weight must be a non-negative integer", part)
+		}
+		mix[op] = w
+		total += w
+	}
+	if total == 0 {
+		return nil, errors.New("at least one weight must be positive")
+	}
+	return mix, nil
+}
+
+// picker chooses operations at random according to the mix weights.
+type picker struct {
+	ops     []string
+	cumul   []int
+	totalWt int
+}
+
+func newPicker(mix map[string]int) *picker {
+	p := &picker{}
+	for _, op := range operations {
+		if w := mix[op]; w > 0 {
+			p.totalWt += w
+			p.ops = append(p.ops, op)
+			p.cumul = append(p.cumul, p.totalWt)
+		}
+	}
+	return p
+}
+
+func (p *picker) pick() string {
+	n := rand.IntN(p.totalWt)
+	i, _ := slices.BinarySearch(p.cumul, n+1)
+	return p.ops[i]
+}
+
+// worker runs requests and keeps its own stats, so recording needs no locks.
+type worker struct {
+	target  target
+	ids     *idPool
+	picker  *picker
+	timeout time.Duration
+	seq     *atomic.Uint64
+	stats   map[string]*opStats
+}
+
+// do runs one request. Latency is measured from scheduled, which in -rate mode
+// is when the request should have started. A slow server then shows up as
+// latency instead of silently lowering the request rate (the "coordinated
+// omission" problem).
+func (w *worker) do(ctx context.Context, scheduled time.Time) {
+	op := w.picker.pick()
+	id := ""
+	if op == "get" {
+		if id = w.ids.random(); id == "" {
+			op = "create"
+		}
+	}
+
+	reqCtx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	var err error
+	switch op {
+	case "create":
+		var newID string
+		newID, err = w.target.create(reqCtx, "load-"+strconv.FormatUint(w.seq.Add(1), 10))
+		if err == nil {
+			w.ids.add(newID)
+		}
+	case "list":
+		err = w.target.list(reqCtx)
+	case "get":
+		err = w.target.get(reqCtx, id)
+	}
+	elapsed := time.Since(scheduled)
+
+	if ctx.Err() != nil {
+		// Cut short by the end of the run; neither a success nor a server error.
+		return
+	}
+	s := w.stats[op]
+	s.requests++
+	s.latency.record(elapsed)
+	if err != nil {
+		s.errors[errorKind(err)]++
+	}
+}
+
+// errorKind turns an error into a short label to group failures by.
+func errorKind(err error) string {
+	var he httpError
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &he):
+		return he.Error()
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &urlErr):
+		return "transport error"
+	default:
+		return err.Error()
+	}
+}
+
+func run(ctx context.Context, t target, cfg config) (report, error) {
+	ids := &idPool{}
+	var seq atomic.Uint64
+	for i := range cfg.seedUsers {
+		seedCtx, cancel := context.WithTimeout(ctx, cfg.timeout)
+		id, err := t.create(seedCtx, "seed-"+strconv.Itoa(i))
+		cancel()
+		if err != nil {
+			return report{}, fmt.Errorf("seed users: %w", err)
+		}
+		ids.add(id)
+	}
+
+	runCtx, cancel := context.WithTimeout(ctx, cfg.duration)
+	defer cancel()
+
+	workers := make([]*worker, cfg.concurrency)
+	for i := range workers {
+		workers[i] = &worker{
+			target:  t,
+			ids:     ids,
+			picker:  newPicker(cfg.mix),
+			timeout: cfg.timeout,
+			seq:     &seq,
+			stats:   make(map[string]*opStats),
+		}
+		for _, op := range operations {
+			workers[i].stats[op] = newOpStats()
+		}
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	if cfg.rate > 0 {
+		// Open model: a scheduler hands out start times at a fixed pace and the
+		// workers pick them up. If all workers are busy the schedule keeps going,
+		// and the wait counts towards latency.
+		slots := make(chan time.Time, cfg.concurrency)
+		for _, w := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for scheduled := range slots {
+					w.do(runCtx, scheduled)
+				}
+			}()
+		}
+		interval := time.Duration(float64(time.Second) / cfg.rate)
+	schedule:
+		for i := 0; ; i++ {
+			next := start.Add(time.Duration(i) * interval)
+			select {
+			case <-runCtx.Done():
+				break schedule
+			case <-time.After(time.Until(next)):
+			}
+			select {
+			case slots <- next:
+			case <-runCtx.Done():
+				break schedule
+			}
+		}
+		close(slots)
+	} else {
+		// Closed model: each worker sends its next request as soon as the
+		// previous one is answered.
+		for _, w := range workers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for runCtx.Err() == nil {
+					w.do(runCtx, time.Now())
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	return buildReport(cfg, workers, start, elapsed, ctx.Err() != nil), nil
+}
+
+func buildReport(cfg config, workers []*worker, start time.Time, elapsed time.Duration, interrupted bool) report {
+	rep := report{
+		Target:      cfg.target,
+		Addr:        cfg.addr,
+		Mode:        "concurrency",
+		Concurrency: cfg.concurrency,
+		Mix:         cfg.mix,
+		StartedAt:   start.UTC(),
+		DurationSec: elapsed.Seconds(),
+		Operations:  make(map[string]opReport),
+		ErrorKinds:  make(map[string]uint64),
+		Interrupted: interrupted,
+	}
+	if cfg.rate > 0 {
+		rep.Mode = "rate"
+		rep.TargetRate = cfg.rate
+	}
+
+	overall := newOpStats()
+	for _, op := range operations {
+		merged := newOpStats()
+		for _, w := range workers {
+			merged.merge(w.stats[op])
+		}
+		overall.merge(merged)
+		if merged.requests == 0 {
+			continue
+		}
+		errs := merged.errorCount()
+		rep.Operations[op] = opReport{
+			Requests:      merged.requests,
+			Errors:        errs,
+			ErrorRate:     float64(errs) / float64(merged.requests),
+			ThroughputRPS: float64(merged.requests) / elapsed.Seconds(),
+			LatencyMS:     merged.latency.summary(),
+			ErrorKinds:    merged.errors,
+		}
+	}
+
+	rep.Requests = overall.requests
+	rep.Errors = overall.errorCount()
+	if rep.Requests > 0 {
+		rep.ErrorRate = float64(rep.Errors) / float64(rep.Requests)
+	}
+	rep.ThroughputRPS = float64(rep.Requests) / elapsed.Seconds()
+	rep.LatencyMS = overall.latency.summary()
+	rep.ErrorKinds = overall.errors
+	return rep
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/cristianmanoliu/learning-golang/grpc-playground/auth"
+	userpb "github.com/cristianmanoliu/learning-golang/grpc-playground/proto_gen/proto"
+)
+
+// target is one of the user services under test. Each method performs a
+// single request and returns an error whose text is used to group failures.
+type target interface {
+	create(ctx context.Context, name string) (id string, err error)
+	list(ctx context.Context) error
+	get(ctx context.Context, id string) error
+	close() error
+}
+
+// idPool remembers created user IDs so get requests hit existing users.
+type idPool struct {
+	mu  sync.Mutex
+	ids []string
+}
+
+func (p *idPool) add(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ids = append(p.ids, id)
+}
+
+// random returns a known ID, or "" if there is none yet.
+func (p *idPool) random() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.ids) == 0 {
+		return ""
+	}
+	return p.ids[rand.IntN(len(p.ids))]
+}
+
+// restTarget drives rest-playground over HTTP/JSON.
+type restTarget struct {
+	baseURL   string
+	tenant    string
+	listLimit int
+	client    *http.Client
+}
+
+func newRESTTarget(baseURL, tenant string, listLimit, conns int) *restTarget {
+	return &restTarget{
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		tenant:    tenant,
+		listLimit: listLimit,
+		client: &http.Client{
+			// The default of 2 idle connections per host would make most
+			// requests pay for a new TCP handshake under load.
+			Transport: &http.Transport{
+				MaxIdleConns:        conns,
+				MaxIdleConnsPerHost: conns,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}
+}
+
+// httpError groups failures by status code, e.g. "http 503".
+type httpError int
+
+func (e httpError) Error() string { return "http " + strconv.Itoa(int(e)) }
+
+func (t *restTarget) do(ctx context.Context, method, path string, body []byte, out any) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, t.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if t.tenant != "" {
+		req.Header.Set("X-Tenant-ID", t.tenant)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return httpError(resp.StatusCode)
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	// Read the body to the end so the connection can be reused.
+	_, err = io.Copy(io.Discard, resp.Body)
+	return err
+}
+
+func (t *restTarget) create(ctx context.Context, name string) (string, error) {
+	body, _ := json.Marshal(map[string]string{"name": name})
+	var u struct {
+		ID int `json:"id"`
+	}
+	if err := t.do(ctx, http.MethodPost, "/users", body, &u); err != nil {
+		return "", err
+	}
+	return strconv.Itoa(u.ID), nil
+}
+
+func (t *restTarget) list(ctx context.Context) error {
+	return t.do(ctx, http.MethodGet, "/users?limit="+strconv.Itoa(t.listLimit), nil, nil)
+}
+
+func (t *restTarget) get(ctx context.Context, id string) error {
+	return t.do(ctx, http.MethodGet, "/users/"+id, nil, nil)
+}
+
+func (t *restTarget) close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
+
+// grpcTarget drives the gRPC UserService. One connection multiplexes all
+// concurrent calls over HTTP/2.
+type grpcTarget struct {
+	conn      *grpc.ClientConn
+	client    userpb.UserServiceClient
+	listLimit int32
+}
+
+func newGRPCTarget(addr string, listLimit int, token string) (*grpcTarget, error) {
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	if token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(auth.BearerToken{Token: token, AllowInsecure: true}))
+	}
+	conn, err := grpc.NewClient(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &grpcTarget{
+		conn:      conn,
+		client:    userpb.NewUserServiceClient(conn),
+		listLimit: int32(listLimit),
+	}, nil
+}
+
+// grpcError groups failures by status code, e.g. "grpc Unavailable".
+func grpcError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return fmt.Errorf("grpc %s", status.Code(err))
+}
+
+func (t *grpcTarget) create(ctx context.Context, name string) (string, error) {
+	resp, err := t.client.CreateUser(ctx, &userpb.CreateUserRequest{Name: name})
+	if err != nil {
+		return "", grpcError(err)
+	}
+	return resp.GetUser().GetId(), nil
+}
+
+func (t *grpcTarget) list(ctx context.Context) error {
+	_, err := t.client.ListUsers(ctx, &userpb.ListUsersRequest{PageSize: t.listLimit})
+	return grpcError(err)
+}
+
+func (t *grpcTarget) get(ctx context.Context, id string) error {
+	_, err := t.client.GetUser(ctx, &userpb.GetUserRequest{Id: id})
+	return grpcError(err)
+}
+
+func (t *grpcTarget) close() error {
+	return t.conn.Close()
+}
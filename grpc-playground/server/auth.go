@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionv1 "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionv1alpha "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"google.golang.org/grpc/status"
+
+	"github.com/cristianmanoliu/learning-golang/grpc-playground/auth"
+	userpb "github.com/cristianmanoliu/learning-golang/grpc-playground/proto_gen/proto"
+)
+
+// methodRoles is the least role each RPC needs. Methods missing from the
+// table are refused, so a new RPC cannot go out unprotected by accident;
+// publicMethods lists the ones that need no token at all.
+var methodRoles = map[string]string{
+	userpb.UserService_GetUser_FullMethodName:         auth.RoleViewer,
+	userpb.UserService_ListUsers_FullMethodName:       auth.RoleViewer,
+	userpb.UserService_WatchUsers_FullMethodName:      auth.RoleViewer,
+	userpb.UserService_CreateUser_FullMethodName:      auth.RoleEditor,
+	userpb.UserService_UpdateUser_FullMethodName:      auth.RoleEditor,
+	userpb.UserService_BulkCreateUsers_FullMethodName: auth.RoleEditor,
+	userpb.UserService_SyncUsers_FullMethodName:       auth.RoleEditor,
+	userpb.UserService_DeleteUser_FullMethodName:      auth.RoleAdmin,
+}
+
+var publicMethods = map[string]bool{
+	// Health checkers and load balancers do not carry tokens.
+	healthpb.Health_Check_FullMethodName: true,
+	healthpb.Health_Watch_FullMethodName: true,
+	// Reflection only reveals the schema, which is no secret here.
+	reflectionv1.ServerReflection_ServerReflectionInfo_FullMethodName:      true,
+	reflectionv1alpha.ServerReflection_ServerReflectionInfo_FullMethodName: true,
+}
+
+// principal is the authenticated caller of an RPC.
+type principal struct {
+	Subject string
+	Role    string
+}
+
+type principalKey struct{}
+
+// principalFrom returns the caller put into ctx by the auth interceptor.
+func principalFrom(ctx context.Context) (principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(principal)
+	return p, ok
+}
+
+// authenticator accepts HS256 JWTs signed with jwtSecret and static keys
+// from a file. Either may be missing.
+type authenticator struct {
+	jwtSecret []byte
+	keys      map[[sha256.Size]byte]principal // by hash, so the map holds no usable keys
+}
+
+// newAuthenticator reads the JWT secret and the static key file. The key
+// file has one key per line, "<key> <subject> <role>"; blank lines and lines
+// starting with # are skipped.
+func newAuthenticator(secretFile, keyFile string) (*authenticator, error) {
+	a := &authenticator{keys: make(map[[sha256.Size]byte]principal)}
+
+	if secretFile != "" {
+		b, err := os.ReadFile(secretFile)
+		if err != nil {
+			return nil, err
+		}
+		a.jwtSecret = []byte(strings.TrimSpace(string(b)))
+		if len(a.jwtSecret) < auth.MinSecretLen {
+			return nil, fmt.Errorf("%s: JWT secret must be at least %d bytes", secretFile, auth.MinSecretLen)
+		}
+	}
+
+	if keyFile != "" {
+		f, err := os.Open(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		sc := bufio.NewScanner(f)
+		for line := 1; sc.Scan(); line++ {
+			text := strings.TrimSpace(sc.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			fields := strings.Fields(text)
+			if len(fields) != 3 || auth.RoleRank(fields[2]) == 0 {
+				return nil, fmt.Errorf("%s:%d: want \"<key> <subject> <viewer|editor|admin>\"", keyFile, line)
+			}
+			a.keys[sha256.Sum256([]byte(fields[0]))] = principal{Subject: fields[1], Role: fields[2]}
+		}
+		if err := sc.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// authenticate resolves a bearer token. Tokens with two dots are JWTs,
+// anything else is looked up as a static key.
+func (a *authenticator) authenticate(token string) (principal, error) {
+	if strings.Count(token, ".") == 2 {
+		if a.jwtSecret == nil {
+			return principal{}, fmt.Errorf("JWTs are not accepted")
+		}
+		c, err := auth.VerifyHS256(a.jwtSecret, token, time.Now())
+		if err != nil {
+			return principal{}, err
+		}
+		if c.Subject == "" || auth.RoleRank(c.Role) == 0 {
+			return principal{}, fmt.Errorf("token needs a sub and a known role")
+		}
+		return principal{Subject: c.Subject, Role: c.Role}, nil
+	}
+	if p, ok := a.keys[sha256.Sum256([]byte(token))]; ok {
+		return p, nil
+	}
+	return principal{}, fmt.Errorf("unknown key")
+}
+
+// authorize authenticates the caller of method and checks methodRoles. The
+// returned context carries the principal.
+func (a *authenticator) authorize(ctx context.Context, method string) (context.Context, error) {
+	if publicMethods[method] {
+		return ctx, nil
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return nil, status.Error(codes.Unauthenticated, "missing authorization metadata")
+	}
+	token, ok := strings.CutPrefix(values[0], "Bearer ")
+	if !ok || token == "" {
+		return nil, status.Error(codes.Unauthenticated, `authorization must be "Bearer <token>"`)
+	}
+	p, err := a.authenticate(token)
+	if err != nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid token: %v", err)
+	}
+
+	need, known := methodRoles[method]
+	if !known {
+		return nil, status.Errorf(codes.PermissionDenied, "%s is not open to any role", method)
+	}
+	if auth.RoleRank(p.Role) < auth.RoleRank(need) {
+		return nil, status.Errorf(codes.PermissionDenied, "%s needs role %s, %s has %s", method, need, p.Subject, p.Role)
+	}
+	return context.WithValue(ctx, principalKey{}, p), nil
+}
+
+func (a *authenticator) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx, err := a.authorize(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *authenticator) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authorize(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &contextStream{ServerStream: ss, ctx: ctx})
+}
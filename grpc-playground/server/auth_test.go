@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/cristianmanoliu/learning-golang/grpc-playground/auth"
+	userpb "github.com/cristianmanoliu/learning-golang/grpc-playground/proto_gen/proto"
+)
+
+var testJWTSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func newTestAuthenticator() *authenticator {
+	a := &authenticator{jwtSecret: testJWTSecret, keys: make(map[[sha256.Size]byte]principal)}
+	for key, p := range map[string]principal{
+		"viewer-key": {Subject: "vic", Role: auth.RoleViewer},
+		"editor-key": {Subject: "eddie", Role: auth.RoleEditor},
+		"admin-key":  {Subject: "ada", Role: auth.RoleAdmin},
+	} {
+		a.keys[sha256.Sum256([]byte(key))] = p
+	}
+	return a
+}
+
+func withAuthorization(value string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", value))
+}
+
+func jwt(t *testing.T, c auth.Claims) string {
+	t.Helper()
+	if c.ExpiresAt == 0 {
+		c.ExpiresAt = time.Now().Add(time.Hour).Unix()
+	}
+	token, err := auth.SignHS256(testJWTSecret, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAuthorize(t *testing.T) {
+	a := newTestAuthenticator()
+	const (
+		get    = userpb.UserService_GetUser_FullMethodName
+		create = userpb.UserService_CreateUser_FullMethodName
+		del    = userpb.UserService_DeleteUser_FullMethodName
+	)
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		method string
+		want   codes.Code
+	}{
+		{"no token", context.Background(), get, codes.Unauthenticated},
+		{"not bearer", withAuthorization("Basic dXNlcjpwdw=="), get, codes.Unauthenticated},
+		{"empty bearer", withAuthorization("Bearer "), get, codes.Unauthenticated},
+		{"unknown key", withAuthorization("Bearer nope"), get, codes.Unauthenticated},
+		{"public without token", context.Background(), healthpb.Health_Check_FullMethodName, codes.OK},
+
+		{"viewer reads", withAuthorization("Bearer viewer-key"), get, codes.OK},
+		{"viewer creates", withAuthorization("Bearer viewer-key"), create, codes.PermissionDenied},
+		{"editor creates", withAuthorization("Bearer editor-key"), create, codes.OK},
+		{"editor deletes", withAuthorization("Bearer editor-key"), del, codes.PermissionDenied},
+		{"admin deletes", withAuthorization("Bearer admin-key"), del, codes.OK},
+		{"method not in the table", withAuthorization("Bearer admin-key"), "/user.UserService/Frobnicate", codes.PermissionDenied},
+
+		{"jwt editor deletes", withAuthorization("Bearer " + jwt(t, auth.Claims{Subject: "ana", Role: auth.RoleEditor})), del, codes.PermissionDenied},
+		{"jwt admin deletes", withAuthorization("Bearer " + jwt(t, auth.Claims{Subject: "ana", Role: auth.RoleAdmin})), del, codes.OK},
+		{"jwt unknown role", withAuthorization("Bearer " + jwt(t, auth.Claims{Subject: "ana", Role: "root"})), get, codes.Unauthenticated},
+		{"jwt without subject", withAuthorization("Bearer " + jwt(t, auth.Claims{Role: auth.RoleAdmin})), get, codes.Unauthenticated},
+		{"jwt expired", withAuthorization("Bearer " + jwt(t, auth.Claims{Subject: "ana", Role: auth.RoleAdmin, ExpiresAt: time.Now().Add(-time.Hour).Unix()})), get, codes.Unauthenticated},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := a.authorize(tc.ctx, tc.method)
+			if got := status.Code(err); got != tc.want {
+				t.Errorf("code %v, want %v (%v)", got, tc.want, err)
+			}
+		})
+	}
+}
+
+func TestAuthorizeSetsPrincipal(t *testing.T) {
+	ctx, err := newTestAuthenticator().authorize(withAuthorization("Bearer editor-key"), userpb.UserService_GetUser_FullMethodName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := principalFrom(ctx); !ok || p != (principal{Subject: "eddie", Role: auth.RoleEditor}) {
+		t.Errorf("principal = %+v, %v", p, ok)
+	}
+}
+
+func TestAuthorizeWithoutJWTSecret(t *testing.T) {
+	a := newTestAuthenticator()
+	a.jwtSecret = nil
+	token := jwt(t, auth.Claims{Subject: "ana", Role: auth.RoleAdmin})
+	if _, err := a.authorize(withAuthorization("Bearer "+token), userpb.UserService_GetUser_FullMethodName); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("JWT without a configured secret: %v, want Unauthenticated", err)
+	}
+}
+
+func TestEveryMethodHasARole(t *testing.T) {
+	for _, m := range userpb.UserService_ServiceDesc.Methods {
+		if _, ok := methodRoles["/"+userpb.UserService_ServiceDesc.ServiceName+"/"+m.MethodName]; !ok {
+			t.Errorf("%s is missing from methodRoles", m.MethodName)
+		}
+	}
+	for _, s := range userpb.UserService_ServiceDesc.Streams {
+		if _, ok := methodRoles["/"+userpb.UserService_ServiceDesc.ServiceName+"/"+s.StreamName]; !ok {
+			t.Errorf("%s is missing from methodRoles", s.StreamName)
+		}
+	}
+}
+
+func TestNewAuthenticatorKeyFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	good := write("keys", "# key subject role\n\nk1 ana admin\nk2 bob viewer\n")
+	a, err := newAuthenticator("", good)
+	if err != nil {
+		t.Fatalf("valid key file: %v", err)
+	}
+	if p, err := a.authenticate("k2"); err != nil || p.Role != auth.RoleViewer {
+		t.Errorf("k2 = %+v, %v", p, err)
+	}
+
+	for name, content := range map[string]string{
+		"unknown role": "k1 ana root\n",
+		"missing role": "k1 ana\n",
+	} {
+		if _, err := newAuthenticator("", write(name, content)); err == nil {
+			t.Errorf("%s: newAuthenticator succeeded", name)
+		}
+	}
+
+	if _, err := newAuthenticator(write("short", "too short\n"), ""); err == nil {
+		t.Error("short JWT secret: newAuthenticator succeeded")
+	}
+}
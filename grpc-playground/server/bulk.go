@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	userpb "github.com/cristianmanoliu/learning-golang/grpc-playground/proto_gen/proto"
+)
+
+// maxBulkFailures caps BulkCreateUsersResponse.failures.
+const maxBulkFailures = 100
+
+func (s *userServer) BulkCreateUsers(stream grpc.ClientStreamingServer[userpb.CreateUserRequest, userpb.BulkCreateUsersResponse]) error {
+	resp := &userpb.BulkCreateUsersResponse{}
+	for index := int32(0); ; index++ {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			// The client gave up; whatever it sent so far stays created.
+			log.Printf("BulkCreateUsers: stream broken after %d items: %v\n", index, err)
+			return err
+		}
+
+		if _, err := s.createUser(req); err != nil {
+			resp.FailedCount++
+			if len(resp.Failures) < maxBulkFailures {
+				resp.Failures = append(resp.Failures, &userpb.BulkCreateFailure{
+					Index:  index,
+					Status: status.Convert(err).Proto(),
+				})
+			}
+			continue
+		}
+		resp.CreatedCount++
+	}
+
+	log.Printf("BulkCreateUsers: created=%d failed=%d\n", resp.CreatedCount, resp.FailedCount)
+
+	return stream.SendAndClose(resp)
+}
+
+func (s *userServer) SyncUsers(stream grpc.BidiStreamingServer[userpb.SyncUsersRequest, userpb.SyncUsersResponse]) error {
+	var created, failed, duplicates int
+	defer func() {
+		log.Printf("SyncUsers: created=%d failed=%d duplicates=%d\n", created, failed, duplicates)
+	}()
+
+	// lastSeq is the last sequence this stream sent for each batch.
+	lastSeq := make(map[string]int64)
+
+	for {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var v fieldViolations
+		if req.GetBatchId() == "" {
+			v.add("batch_id", "is required")
+		}
+		if req.GetSequence() <= 0 {
+			v.add("sequence", "must be positive")
+		} else if last, ok := lastSeq[req.GetBatchId()]; ok && req.GetSequence() <= last {
+			v.add("sequence", "must increase, got %d after %d", req.GetSequence(), last)
+		}
+		if err := v.err(); err != nil {
+			// Without a batch and sequence the item cannot be acknowledged,
+			// and out of order it could be taken for a resend, so the whole
+			// stream fails.
+			return err
+		}
+		lastSeq[req.GetBatchId()] = req.GetSequence()
+
+		resp, dup := s.batches.handle(req.GetBatchId(), req.GetSequence(), func() *userpb.SyncUsersResponse {
+			resp := &userpb.SyncUsersResponse{Sequence: req.GetSequence()}
+			u, err := s.createUser(req.GetUser())
+			if err != nil {
+				resp.Error = status.Convert(err).Proto()
+				failed++
+				return resp
+			}
+			resp.User = u
+			created++
+			return resp
+		})
+		if dup {
+			duplicates++
+		}
+
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// maxSyncBatches bounds how many batches syncBatches remembers. A client that
+// reconnects after its batch was forgotten gets its remaining items created
+// again, so this should comfortably exceed the batches in flight.
+const maxSyncBatches = 1024
+
+// maxSyncResults bounds how many acknowledgements each batch keeps for
+// resending. A client only resends what it sent since its last ack arrived,
+// so this should comfortably exceed its window of unacknowledged items.
+const maxSyncResults = 256
+
+// syncBatches remembers, per SyncUsers batch, the items applied and their
+// acknowledgements. That is what makes resending unacknowledged items after
+// a reconnect safe.
+type syncBatches struct {
+	mu      sync.Mutex // guards batches; each batch has its own lock
+	batches map[string]*syncBatch
+}
+
+type syncBatch struct {
+	mu      sync.Mutex
+	used    time.Time // guarded by syncBatches.mu
+	results map[int64]*userpb.SyncUsersResponse
+	order   []int64 // sequences in results, oldest first
+	// forgotten is the highest sequence dropped from results. Whether an
+	// item at or below it was applied is no longer known.
+	forgotten int64
+}
+
+func newSyncBatches() *syncBatches {
+	return &syncBatches{batches: make(map[string]*syncBatch)}
+}
+
+// handle runs apply unless seq was already applied for batchID, and returns
+// the acknowledgement to send. For an item applied before it returns the
+// original acknowledgement, user or error included, with duplicate set; if
+// that has been forgotten, only the sequence and duplicate are set. The
+// batch's lock is held throughout, so two streams resending the same batch
+// cannot both apply an item, while other batches go on in parallel.
+func (b *syncBatches) handle(batchID string, seq int64, apply func() *userpb.SyncUsersResponse) (resp *userpb.SyncUsersResponse, dup bool) {
+	b.mu.Lock()
+	batch, ok := b.batches[batchID]
+	if !ok {
+		if len(b.batches) >= maxSyncBatches {
+			b.evictOldest()
+		}
+		batch = &syncBatch{results: make(map[int64]*userpb.SyncUsersResponse)}
+		b.batches[batchID] = batch
+	}
+	batch.used = time.Now()
+	b.mu.Unlock()
+
+	batch.mu.Lock()
+	defer batch.mu.Unlock()
+
+	if acked, ok := batch.results[seq]; ok {
+		resp = proto.Clone(acked).(*userpb.SyncUsersResponse)
+		resp.Duplicate = true
+		return resp, true
+	}
+	if seq <= batch.forgotten {
+		return &userpb.SyncUsersResponse{Sequence: seq, Duplicate: true}, true
+	}
+	resp = apply()
+
+	batch.results[seq] = resp
+	batch.order = append(batch.order, seq)
+	if len(batch.order) > maxSyncResults {
+		batch.forgotten = max(batch.forgotten, batch.order[0])
+		delete(batch.results, batch.order[0])
+		batch.order = batch.order[1:]
+	}
+	return resp, false
+}
+
+// evictOldest forgets the least recently used batch. Callers must hold b.mu.
+func (b *syncBatches) evictOldest() {
+	var oldestID string
+	var oldest time.Time
+	for id, batch := range b.batches {
+		if oldestID == "" || batch.used.Before(oldest) {
+			oldestID, oldest = id, batch.used
+		}
+	}
+	delete(b.batches, oldestID)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// Handlers return errors built by the helpers below, never plain Go errors:
+// gRPC reports those as codes.Unknown, which tells a client nothing.
+//
+//   - InvalidArgument: the request itself is wrong; details carry an
+//     errdetails.BadRequest with one violation per offending field.
+//   - NotFound: the user does not exist.
+//   - AlreadyExists: CreateUser asked for an ID that is taken.
+//   - FailedPrecondition: the request is valid but its version check did not
+//     hold; details carry an errdetails.PreconditionFailure.
+
+// fieldViolations collects validation failures for one request, so a client
+// gets all of them at once instead of fixing one field per round trip.
+type fieldViolations []*errdetails.BadRequest_FieldViolation
+
+// add records a problem with field, named by its path in the request message
+// (e.g. "user.name").
+func (v *fieldViolations) add(field, format string, args ...any) {
+	*v = append(*v, &errdetails.BadRequest_FieldViolation{
+		Field:       field,
+		Description: fmt.Sprintf(format, args...),
+	})
+}
+
+// err returns nil when nothing was added, otherwise an InvalidArgument status
+// whose message lists every violation and whose details carry them as an
+// errdetails.BadRequest.
+func (v fieldViolations) err() error {
+	if len(v) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(v))
+	for i, fv := range v {
+		msgs[i] = fv.GetField() + ": " + fv.GetDescription()
+	}
+	return withDetails(
+		status.New(codes.InvalidArgument, "invalid request: "+strings.Join(msgs, "; ")),
+		&errdetails.BadRequest{FieldViolations: v},
+	)
+}
+
+func userNotFound(id string) error {
+	return withDetails(
+		status.Newf(codes.NotFound, "user %q not found", id),
+		&errdetails.ResourceInfo{ResourceType: "user.User", ResourceName: id},
+	)
+}
+
+func userExists(id string) error {
+	return withDetails(
+		status.Newf(codes.AlreadyExists, "user %q already exists", id),
+		&errdetails.ResourceInfo{ResourceType: "user.User", ResourceName: id},
+	)
+}
+
+// versionMismatch reports that the caller's copy of a user is stale.
+func versionMismatch(id string, want, have int64) error {
+	return withDetails(
+		status.Newf(codes.FailedPrecondition, "user %q is at version %d, request expected %d", id, have, want),
+		&errdetails.PreconditionFailure{Violations: []*errdetails.PreconditionFailure_Violation{{
+			Type:        "VERSION",
+			Subject:     "user/" + id,
+			Description: "expected version " + strconv.FormatInt(want, 10) + ", current is " + strconv.FormatInt(have, 10),
+		}}},
+	)
+}
+
+// withDetails attaches details to st. Adding them only fails if a detail
+// cannot be marshalled, in which case the bare status is still worth
+// returning.
+func withDetails(st *status.Status, details ...protoadapt.MessageV1) error {
+	if withD, err := st.WithDetails(details...); err == nil {
+		st = withD
+	}
+	return st.Err()
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/cristianmanoliu/learning-golang/grpc-playground/gateway"
+)
+
+// startGateway serves the HTTP/JSON gateway on addr. It calls the gRPC server
+// listening on grpcAddr over loopback rather than the handlers directly, so
+// HTTP requests get the interceptors too and streaming RPCs work. Close the
+// returned connection after shutting down the server.
+func startGateway(addr string, grpcAddr net.Addr) (*http.Server, *grpc.ClientConn, error) {
+	target := grpcAddr.String()
+	if tcp, ok := grpcAddr.(*net.TCPAddr); ok {
+		// The listener is usually on all interfaces, e.g. [::]:50051.
+		target = net.JoinHostPort("localhost", strconv.Itoa(tcp.Port))
+	}
+	conn, err := grpc.NewClient(target, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, nil, err
+	}
+	handler, err := gateway.NewHandler(context.Background(), conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	srv := &http.Server{Addr: addr, Handler: handler}
+	go func() {
+		log.Printf("HTTP gateway listening on %s\n", addr)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("gateway: %v", err)
+		}
+	}()
+	return srv, conn, nil
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	userpb "github.com/cristianmanoliu/learning-golang/grpc-playground/proto_gen/proto"
+)
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 1000
+)
+
+func (s *userServer) ListUsers(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
+	var v fieldViolations
+	if req.GetPageSize() < 0 {
+		v.add("page_size", "must not be negative")
+	}
+	filter, err := parseFilter(req.GetFilter())
+	if err != nil {
+		v.add("filter", "%v", err)
+	}
+	order, err := parseOrderBy(req.GetOrderBy())
+	if err != nil {
+		v.add("order_by", "%v", err)
+	}
+	var after *userKey
+	if req.GetPageToken() != "" {
+		// A token only makes sense for the query that produced it.
+		if after, err = s.pages.decode(req.GetPageToken(), req.GetFilter(), req.GetOrderBy()); err != nil {
+			v.add("page_token", "%v", err)
+		}
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	pageSize := int(req.GetPageSize())
+	switch {
+	case pageSize == 0:
+		pageSize = defaultPageSize
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	s.mu.Lock()
+	var matches []*userpb.User
+	for _, id := range s.order {
+		if u := s.users[id]; filter.match(u) {
+			matches = append(matches, u)
+		}
+	}
+	s.mu.Unlock()
+
+	slices.SortFunc(matches, func(a, b *userpb.User) int {
+		return order.compare(keyOf(a), keyOf(b))
+	})
+
+	// Continue right after the last user of the previous page. Going by its
+	// sort key rather than by position means users created or deleted in
+	// between do not make the next page skip or repeat anyone.
+	start := 0
+	if after != nil {
+		start, _ = slices.BinarySearchFunc(matches, *after, func(u *userpb.User, k userKey) int {
+			if c := order.compare(keyOf(u), k); c != 0 {
+				return c
+			}
+			return -1 // k itself was on the previous page
+		})
+	}
+	end := min(start+pageSize, len(matches))
+
+	resp := &userpb.ListUsersResponse{Users: matches[start:end]}
+	if end < len(matches) {
+		resp.NextPageToken = s.pages.encode(keyOf(matches[end-1]), req.GetFilter(), req.GetOrderBy())
+	}
+
+	log.Printf("ListUsers: returning %d of %d users\n", len(resp.Users), len(matches))
+
+	return resp, nil
+}
+
+// userKey holds the fields users can be sorted by. The ID is unique, so two
+// different users never compare equal.
+type userKey struct {
+	Name       string `json:"n"`
+	ID         string `json:"i"`
+	CreateTime int64  `json:"t"` // Unix nanoseconds
+}
+
+func keyOf(u *userpb.User) userKey {
+	return userKey{Name: u.GetName(), ID: u.GetId(), CreateTime: u.GetCreateTime().AsTime().UnixNano()}
+}
+
+type sortField struct {
+	name string
+	desc bool
+}
+
+// userOrder is a parsed order_by. It always ends with id, so the order is
+// total.
+type userOrder []sortField
+
+func parseOrderBy(s string) (userOrder, error) {
+	var order userOrder
+	seen := make(map[string]bool)
+	if strings.TrimSpace(s) != "" {
+		for _, part := range strings.Split(s, ",") {
+			words := strings.Fields(part)
+			if len(words) == 0 || len(words) > 2 {
+				return nil, fmt.Errorf("%q is not a field followed by an optional asc or desc", strings.TrimSpace(part))
+			}
+			f := sortField{name: words[0]}
+			switch f.name {
+			case "name", "id", "create_time":
+			default:
+				return nil, fmt.Errorf("cannot sort by %q", f.name)
+			}
+			if len(words) == 2 {
+				switch words[1] {
+				case "asc":
+				case "desc":
+					f.desc = true
+				default:
+					return nil, fmt.Errorf("%q is neither asc nor desc", words[1])
+				}
+			}
+			if seen[f.name] {
+				return nil, fmt.Errorf("%q appears twice", f.name)
+			}
+			seen[f.name] = true
+			order = append(order, f)
+		}
+	}
+	if len(order) == 0 {
+		order = userOrder{{name: "create_time"}}
+	}
+	if !seen["id"] {
+		order = append(order, sortField{name: "id"})
+	}
+	return order, nil
+}
+
+func (o userOrder) compare(a, b userKey) int {
+	for _, f := range o {
+		var c int
+		switch f.name {
+		case "name":
+			c = strings.Compare(a.Name, b.Name)
+		case "id":
+			c = strings.Compare(a.ID, b.ID)
+		case "create_time":
+			c = cmp.Compare(a.CreateTime, b.CreateTime)
+		}
+		if f.desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// userFilter is a parsed filter: every condition must hold.
+type userFilter []filterCond
+
+type filterCond struct {
+	field string // "name" or "id"
+	exact bool   // = rather than :
+	value string
+}
+
+func (f userFilter) match(u *userpb.User) bool {
+	for _, c := range f {
+		got := u.GetName()
+		if c.field == "id" {
+			got = u.GetId()
+		}
+		if c.exact {
+			if got != c.value {
+				return false
+			}
+			continue
+		}
+		// path.Match treats * as "any characters except /", and names may
+		// contain a slash; it is good enough for a playground.
+		if ok, _ := path.Match(strings.ToLower(c.value), strings.ToLower(got)); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// parseFilter reads conditions like name:"Cri*" id="abc", optionally joined
+// by AND. Values may be quoted Go-style or be a single bare word.
+func parseFilter(s string) (userFilter, error) {
+	var f userFilter
+	rest := strings.TrimSpace(s)
+	for rest != "" {
+		if len(f) > 0 {
+			if after, ok := strings.CutPrefix(rest, "AND "); ok {
+				rest = strings.TrimSpace(after)
+			}
+		}
+
+		i := strings.IndexAny(rest, ":=")
+		if i <= 0 {
+			return nil, fmt.Errorf("expected field:value or field=value at %q", rest)
+		}
+		c := filterCond{field: rest[:i], exact: rest[i] == '='}
+		switch c.field {
+		case "name", "id":
+		default:
+			return nil, fmt.Errorf("cannot filter on %q", c.field)
+		}
+		rest = rest[i+1:]
+
+		if strings.HasPrefix(rest, `"`) {
+			quoted, err := strconv.QuotedPrefix(rest)
+			if err != nil {
+				return nil, fmt.Errorf("unterminated string at %q", rest)
+			}
+			c.value, _ = strconv.Unquote(quoted)
+			rest = rest[len(quoted):]
+		} else {
+			end := strings.IndexByte(rest, ' ')
+			if end < 0 {
+				end = len(rest)
+			}
+			c.value, rest = rest[:end], rest[end:]
+		}
+		if !c.exact {
+			if _, err := path.Match(c.value, ""); err != nil {
+				return nil, fmt.Errorf("bad pattern %q", c.value)
+			}
+		}
+		f = append(f, c)
+		rest = strings.TrimSpace(rest)
+	}
+	return f, nil
+}
+
+// pageTokens signs page tokens with a key made up at startup, so clients
+// cannot forge a position or reuse a token across queries, and tokens stop
+// working when the server restarts.
+type pageTokens struct {
+	key []byte
+}
+
+// pageToken is what a token carries before signing.
+type pageToken struct {
+	After userKey `json:"a"`
+	Query string  `json:"q"` // hash of filter and order_by
+}
+
+func newPageTokens() *pageTokens {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		log.Fatalf("page token key: %v", err)
+	}
+	return &pageTokens{key: key}
+}
+
+func queryHash(filter, orderBy string) string {
+	sum := sha256.Sum256([]byte(filter + "\x00" + orderBy))
+	return base64.RawURLEncoding.EncodeToString(sum[:8])
+}
+
+func (p *pageTokens) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, p.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+func (p *pageTokens) encode(after userKey, filter, orderBy string) string {
+	payload, _ := json.Marshal(pageToken{After: after, Query: queryHash(filter, orderBy)})
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(p.sign(payload))
+}
+
+func (p *pageTokens) decode(token, filter, orderBy string) (*userKey, error) {
+	errInvalid := errors.New("is invalid or has expired; start again without it")
+
+	payloadPart, sigPart, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errInvalid
+	}
+	payload, err1 := base64.RawURLEncoding.DecodeString(payloadPart)
+	sig, err2 := base64.RawURLEncoding.DecodeString(sigPart)
+	if err1 != nil || err2 != nil || !hmac.Equal(sig, p.sign(payload)) {
+		return nil, errInvalid
+	}
+	var t pageToken
+	if err := json.Unmarshal(payload, &t); err != nil {
+		return nil, errInvalid
+	}
+	if t.Query != queryHash(filter, orderBy) {
+		return nil, errors.New("was issued for a different filter or order_by")
+	}
+	return &t.After, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	userpb "github.com/cristianmanoliu/learning-golang/grpc-playground/proto_gen/proto"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		in   string
+		want userFilter
+	}{
+		{"", nil},
+		{"name:Cri*", userFilter{{field: "name", value: "Cri*"}}},
+		{`name:"Ana Maria"`, userFilter{{field: "name", value: "Ana Maria"}}},
+		{`id="a\"b"`, userFilter{{field: "id", exact: true, value: `a"b`}}},
+		{`name:a* AND id=x`, userFilter{{field: "name", value: "a*"}, {field: "id", exact: true, value: "x"}}},
+		{`name:a*  id=x`, userFilter{{field: "name", value: "a*"}, {field: "id", exact: true, value: "x"}}},
+	}
+	for _, tc := range tests {
+		got, err := parseFilter(tc.in)
+		if err != nil {
+			t.Errorf("parseFilter(%q): %v", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseFilter(%q) = %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, in := range []string{
+		"name",          // no operator
+		":Ana",          // no field
+		"email:a@b",     // unknown field
+		`name:"Ana`,     // unterminated string
+		"name:[",        // bad pattern
+		"name:a AND id", // second condition incomplete
+	} {
+		if _, err := parseFilter(in); err == nil {
+			t.Errorf("parseFilter(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	u := &userpb.User{Id: "u1", Name: "Cristian"}
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{"", true},
+		{"name:cri*", true}, // : is case-insensitive
+		{"name=cri*", false},
+		{"name=Cristian", true},
+		{"name:Cri* AND id=u1", true},
+		{"name:Cri* AND id=u2", false},
+	}
+	for _, tc := range tests {
+		f, err := parseFilter(tc.filter)
+		if err != nil {
+			t.Fatalf("parseFilter(%q): %v", tc.filter, err)
+		}
+		if got := f.match(u); got != tc.want {
+			t.Errorf("%q matches %v, want %v", tc.filter, got, tc.want)
+		}
+	}
+}
+
+func TestParseOrderBy(t *testing.T) {
+	tests := []struct {
+		in   string
+		want userOrder
+	}{
+		{"", userOrder{{name: "create_time"}, {name: "id"}}},
+		{"name", userOrder{{name: "name"}, {name: "id"}}},
+		{"name desc, create_time asc", userOrder{{name: "name", desc: true}, {name: "create_time"}, {name: "id"}}},
+		{"id desc", userOrder{{name: "id", desc: true}}},
+	}
+	for _, tc := range tests {
+		got, err := parseOrderBy(tc.in)
+		if err != nil {
+			t.Errorf("parseOrderBy(%q): %v", tc.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("parseOrderBy(%q) = %+v, want %+v", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestParseOrderByErrors(t *testing.T) {
+	for _, in := range []string{
+		"email",          // unknown field
+		"name up",        // neither asc nor desc
+		"name asc desc",  // too many words
+		"name,",          // empty part
+		"name, name asc", // twice
+	} {
+		if _, err := parseOrderBy(in); err == nil {
+			t.Errorf("parseOrderBy(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestPageTokens(t *testing.T) {
+	p := newPageTokens()
+	after := userKey{Name: "Ana", ID: "u1", CreateTime: 42}
+	token := p.encode(after, "name:A*", "name")
+
+	got, err := p.decode(token, "name:A*", "name")
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if *got != after {
+		t.Errorf("decode = %+v, want %+v", *got, after)
+	}
+
+	payload, sig, _ := strings.Cut(token, ".")
+	flip := func(s string) string {
+		b := []byte(s)
+		if b[0] == 'A' {
+			b[0] = 'B'
+		} else {
+			b[0] = 'A'
+		}
+		return string(b)
+	}
+	for name, bad := range map[string]string{
+		"tampered payload":   flip(payload) + "." + sig,
+		"tampered signature": payload + "." + flip(sig),
+		"no signature":       payload,
+		"not base64":         "!!!." + sig,
+	} {
+		if _, err := p.decode(bad, "name:A*", "name"); err == nil {
+			t.Errorf("%s: decode succeeded", name)
+		}
+	}
+
+	// A restarted server has a new key.
+	if _, err := newPageTokens().decode(token, "name:A*", "name"); err == nil {
+		t.Error("token from another key: decode succeeded")
+	}
+
+	for _, q := range [][2]string{{"name:B*", "name"}, {"name:A*", "name desc"}} {
+		if _, err := p.decode(token, q[0], q[1]); err == nil || !strings.Contains(err.Error(), "different filter") {
+			t.Errorf("token reused for filter %q order_by %q: %v", q[0], q[1], err)
+		}
+	}
+}
+
+// listAll pages through ListUsers and returns the names in order. before is
+// called ahead of every page but the first.
+func listAll(t *testing.T, s *userServer, req *userpb.ListUsersRequest, before func()) []string {
+	t.Helper()
+	var names []string
+	for page := 0; ; page++ {
+		if page > 0 && before != nil {
+			before()
+		}
+		resp, err := s.ListUsers(context.Background(), req)
+		if err != nil {
+			t.Fatalf("ListUsers: %v", err)
+		}
+		for _, u := range resp.GetUsers() {
+			names = append(names, u.GetName())
+		}
+		if resp.GetNextPageToken() == "" {
+			return names
+		}
+		req.PageToken = resp.GetNextPageToken()
+	}
+}
+
+func TestListUsersPages(t *testing.T) {
+	s := newUserServer()
+	for _, name := range []string{"eve", "bob", "dan", "ann", "cat"} {
+		if _, err := s.createUser(&userpb.CreateUserRequest{Name: name, UserId: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := listAll(t, s, &userpb.ListUsersRequest{PageSize: 2, OrderBy: "name"}, nil)
+	if want := []string{"ann", "bob", "cat", "dan", "eve"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pages = %v, want %v", got, want)
+	}
+}
+
+// The next page starts after the last key of the previous one, so users
+// created or deleted in between make it neither skip nor repeat anyone.
+func TestListUsersResumesAfterLastKey(t *testing.T) {
+	s := newUserServer()
+	for _, name := range []string{"bob", "dan", "fay", "hal"} {
+		if _, err := s.createUser(&userpb.CreateUserRequest{Name: name, UserId: name}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changed := false
+	got := listAll(t, s, &userpb.ListUsersRequest{PageSize: 2, OrderBy: "name"}, func() {
+		if changed {
+			return
+		}
+		changed = true
+		// After page one (bob, dan): delete its last user, add one before
+		// and one after the cursor.
+		if _, err := s.DeleteUser(context.Background(), &userpb.DeleteUserRequest{Id: "dan"}); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"ann", "eve"} {
+			if _, err := s.createUser(&userpb.CreateUserRequest{Name: name, UserId: name}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+	if want := []string{"bob", "dan", "eve", "fay", "hal"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pages = %v, want %v", got, want)
+	}
+}
+
+func TestListUsersRejectsBadRequests(t *testing.T) {
+	s := newUserServer()
+	token := s.pages.encode(userKey{Name: "a", ID: "a"}, "", "name")
+
+	for name, req := range map[string]*userpb.ListUsersRequest{
+		"negative page size": {PageSize: -1},
+		"bad filter":         {Filter: "email:x"},
+		"bad order_by":       {OrderBy: "email"},
+		"foreign token":      {OrderBy: "id", PageToken: token},
+		"garbage token":      {PageToken: "garbage"},
+	} {
+		_, err := s.ListUsers(context.Background(), req)
+		if status.Code(err) != codes.InvalidArgument {
+			t.Errorf("%s: %v, want InvalidArgument", name, err)
+		}
+	}
+}
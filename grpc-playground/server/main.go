@@ -2,68 +2,352 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"regexp"
+	"slices"
 	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/uuid"
 	"google.golang.org/grpc"
+	healthpkg "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
 
 	userpb "github.com/cristianmanoliu/learning-golang/grpc-playground/proto_gen/proto"
 )
 
+// userServer keeps users in memory, indexed by ID. Stored messages are never
+// modified in place: UpdateUser swaps in a new copy, so a *userpb.User handed
+// to a response can be marshalled without holding mu.
 type userServer struct {
 	userpb.UnimplementedUserServiceServer
 
-	mu    sync.Mutex
-	users []*userpb.User
+	mu     sync.Mutex
+	users  map[string]*userpb.User
+	order  []string // IDs in creation order
+	events *eventLog
+
+	batches *syncBatches
+	pages   *pageTokens
+}
+
+func newUserServer() *userServer {
+	return &userServer{
+		users:   make(map[string]*userpb.User),
+		events:  newEventLog(),
+		batches: newSyncBatches(),
+		pages:   newPageTokens(),
+	}
+}
+
+// maxNameLen bounds User.name, in characters.
+const maxNameLen = 100
+
+// userIDPattern is the shape of a caller-chosen user ID: lowercase letters,
+// digits and hyphens, starting with a letter or digit.
+var userIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+func validateName(v *fieldViolations, field, name string) {
+	switch {
+	case name == "":
+		v.add(field, "is required")
+	case utf8.RuneCountInString(name) > maxNameLen:
+		v.add(field, "must be at most %d characters", maxNameLen)
+	}
 }
 
 func (s *userServer) CreateUser(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.CreateUserResponse, error) {
-	if req.GetName() == "" {
-		return nil, fmt.Errorf("name is required")
+	u, err := s.createUser(req)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("CreateUser: id=%s name=%s\n", u.Id, u.Name)
+
+	return &userpb.CreateUserResponse{User: u}, nil
+}
+
+// createUser validates req and stores the new user. CreateUser,
+// BulkCreateUsers and SyncUsers all go through it.
+func (s *userServer) createUser(req *userpb.CreateUserRequest) (*userpb.User, error) {
+	var v fieldViolations
+	validateName(&v, "name", req.GetName())
+	if id := req.GetUserId(); id != "" && !userIDPattern.MatchString(id) {
+		v.add("user_id", "must be 1-63 lowercase letters, digits or hyphens")
+	}
+	if err := v.err(); err != nil {
+		return nil, err
 	}
 
 	u := &userpb.User{
-		Id:   uuid.NewString(),
-		Name: req.GetName(),
+		Id:         req.GetUserId(),
+		Name:       req.GetName(),
+		Version:    1,
+		CreateTime: timestamppb.Now(),
+	}
+	if u.Id == "" {
+		u.Id = uuid.NewString()
 	}
 
 	s.mu.Lock()
-	s.users = append(s.users, u)
+	if _, taken := s.users[u.Id]; taken {
+		s.mu.Unlock()
+		return nil, userExists(u.Id)
+	}
+	s.users[u.Id] = u
+	s.order = append(s.order, u.Id)
+	s.events.publish(userpb.UserEvent_CREATED, u)
 	s.mu.Unlock()
 
-	log.Printf("CreateUser: id=%s name=%s\n", u.Id, u.Name)
+	return u, nil
+}
 
-	return &userpb.CreateUserResponse{User: u}, nil
+func (s *userServer) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.GetUserResponse, error) {
+	var v fieldViolations
+	if req.GetId() == "" {
+		v.add("id", "is required")
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	u, ok := s.users[req.GetId()]
+	s.mu.Unlock()
+	if !ok {
+		return nil, userNotFound(req.GetId())
+	}
+	return &userpb.GetUserResponse{User: u}, nil
 }
 
-func (s *userServer) ListUsers(ctx context.Context, _ *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
+// updatableUserFields are the User fields UpdateUser may overwrite. An empty
+// update mask means all of them.
+var updatableUserFields = []string{"name"}
+
+func (s *userServer) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.UpdateUserResponse, error) {
+	in := req.GetUser()
+
+	var v fieldViolations
+	if in.GetId() == "" {
+		v.add("user.id", "is required")
+	}
+	paths := req.GetUpdateMask().GetPaths()
+	if len(paths) == 0 {
+		paths = updatableUserFields
+	}
+	for i, path := range paths {
+		field := fmt.Sprintf("update_mask.paths[%d]", i)
+		switch path {
+		case "name":
+			validateName(&v, "user.name", in.GetName())
+		case "id", "version", "create_time":
+			// Output only. AIP-134 says to ignore them rather than fail, so
+			// a client can send back a User it got from GetUser unchanged.
+			// The gateway relies on that too: for a PATCH without a mask it
+			// builds one from every field in the JSON body.
+		default:
+			v.add(field, "%q is not a field of User", path)
+		}
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	usersCopy := make([]*userpb.User, len(s.users))
-	copy(usersCopy, s.users)
+	old, ok := s.users[in.GetId()]
+	if !ok {
+		return nil, userNotFound(in.GetId())
+	}
+	if in.GetVersion() != 0 && in.GetVersion() != old.GetVersion() {
+		return nil, versionMismatch(old.GetId(), in.GetVersion(), old.GetVersion())
+	}
+	u := proto.Clone(old).(*userpb.User)
+	for _, path := range paths {
+		switch path {
+		case "name":
+			u.Name = in.GetName()
+		}
+	}
+	u.Version++
+	s.users[u.Id] = u
+	s.events.publish(userpb.UserEvent_UPDATED, u)
+
+	log.Printf("UpdateUser: id=%s fields=%v version=%d\n", u.Id, paths, u.Version)
+
+	return &userpb.UpdateUserResponse{User: u}, nil
+}
+
+func (s *userServer) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest) (*userpb.DeleteUserResponse, error) {
+	var v fieldViolations
+	if req.GetId() == "" {
+		v.add("id", "is required")
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
 
-	log.Printf("ListUsers: returning %d users\n", len(usersCopy))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id := req.GetId()
+	old, ok := s.users[id]
+	if !ok {
+		return nil, userNotFound(id)
+	}
+	if req.GetVersion() != 0 && req.GetVersion() != old.GetVersion() {
+		return nil, versionMismatch(id, req.GetVersion(), old.GetVersion())
+	}
+	delete(s.users, id)
+	s.order = slices.DeleteFunc(s.order, func(v string) bool { return v == id })
+	s.events.publish(userpb.UserEvent_DELETED, old)
 
-	return &userpb.ListUsersResponse{Users: usersCopy}, nil
+	if p, ok := principalFrom(ctx); ok {
+		log.Printf("DeleteUser: id=%s by=%s\n", id, p.Subject)
+	} else {
+		log.Printf("DeleteUser: id=%s\n", id)
+	}
+
+	return &userpb.DeleteUserResponse{}, nil
 }
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	lis, err := net.Listen("tcp", ":50051")
+	addr := flag.String("addr", ":50051", "address to serve gRPC on")
+	httpAddr := flag.String("http-addr", "", "address to serve the HTTP/JSON gateway on, e.g. :8080; empty disables it")
+	metricsAddr := flag.String("metrics-addr", ":9090", "address to serve Prometheus /metrics on; empty disables it")
+	logFormat := flag.String("log-format", "text", "log format: text or json")
+	jwtSecretFile := flag.String("jwt-secret-file", "", "file with the HMAC secret for HS256 bearer tokens")
+	authKeyFile := flag.String("auth-key-file", "", `file of static bearer keys, one "<key> <subject> <role>" per line`)
+	shutdownDelay := flag.Duration("shutdown-delay", 5*time.Second, "how long health checks report NOT_SERVING before the server stops taking calls")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "how long to wait for in-flight calls and streams on shutdown")
+	flag.Parse()
+
+	// slog becomes the backend of the log package too, so the handlers'
+	// log.Printf lines come out in the same format as the RPC log.
+	switch *logFormat {
+	case "text":
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	default:
+		log.Fatalf("unknown -log-format %q", *logFormat)
+	}
+
+	metrics := newRPCMetrics()
+	var metricsServer *http.Server
+	if *metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("GET /metrics", metrics)
+		metricsServer = &http.Server{Addr: *metricsAddr, Handler: mux}
+		go func() {
+			log.Printf("metrics listening on %s\n", *metricsAddr)
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatalf("metrics: %v", err)
+			}
+		}()
+	}
+
+	lis, err := net.Listen("tcp", *addr)
 	if err != nil {
 		log.Fatalf("listen: %v", err)
 	}
 
-	grpcServer := grpc.NewServer()
-	userpb.RegisterUserServiceServer(grpcServer, &userServer{})
+	var authn *authenticator
+	if *jwtSecretFile != "" || *authKeyFile != "" {
+		if authn, err = newAuthenticator(*jwtSecretFile, *authKeyFile); err != nil {
+			log.Fatalf("auth: %v", err)
+		}
+	} else {
+		log.Println("auth disabled: pass -jwt-secret-file or -auth-key-file to require tokens")
+	}
+
+	grpcServer := grpc.NewServer(serverInterceptors(metrics, authn)...)
+	userpb.RegisterUserServiceServer(grpcServer, newUserServer())
+
+	// Health: "" stands for the server as a whole, the others for single
+	// services, as grpc.health.v1 clients and load balancers expect.
+	health := healthpkg.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, health)
+	health.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	health.SetServingStatus(userpb.UserService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+
+	// Reflection lets grpcurl and similar tools list services and fetch
+	// their descriptors, e.g. grpcurl -plaintext localhost:50051 describe user.UserService
+	reflection.Register(grpcServer)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("gRPC server listening on %s\n", *addr)
+		serveErr <- grpcServer.Serve(lis)
+	}()
 
-	log.Println("gRPC server listening on :50051")
-	if err := grpcServer.Serve(lis); err != nil {
+	var gatewayServer *http.Server
+	if *httpAddr != "" {
+		var gatewayConn *grpc.ClientConn
+		if gatewayServer, gatewayConn, err = startGateway(*httpAddr, lis.Addr()); err != nil {
+			log.Fatalf("gateway: %v", err)
+		}
+		defer gatewayConn.Close()
+	}
+
+	select {
+	case err := <-serveErr:
 		log.Fatalf("Serve: %v", err)
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process right away
+
+	// Report NOT_SERVING first and keep serving for a while, so load
+	// balancers and health-watching clients move away before calls fail.
+	log.Printf("shutting down: NOT_SERVING for %s\n", *shutdownDelay)
+	health.Shutdown()
+	time.Sleep(*shutdownDelay)
+
+	// GracefulStop waits for every call, and WatchUsers streams never end on
+	// their own, so cut them off after the timeout. The gateway goes first:
+	// its requests are calls to the gRPC server.
+	stopCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if gatewayServer != nil {
+		if err := gatewayServer.Shutdown(stopCtx); err != nil {
+			_ = gatewayServer.Close()
+		}
+	}
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-stopCtx.Done():
+		log.Println("shutdown timeout: cancelling remaining calls")
+		grpcServer.Stop()
+	}
+
+	if metricsServer != nil {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = metricsServer.Shutdown(shutdownCtx)
 	}
+	log.Println("server stopped")
 }
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the latency histogram
+// buckets; the same defaults as the Prometheus client libraries.
+var latencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// rpcMetrics counts RPCs per method and status code and records their
+// latency, and serves the numbers in the Prometheus text format.
+type rpcMetrics struct {
+	mu      sync.Mutex
+	methods map[string]*methodMetrics
+}
+
+type methodMetrics struct {
+	streaming bool
+	started   uint64
+	handled   map[codes.Code]uint64
+	buckets   []uint64 // counts per latencyBuckets entry, not cumulative
+	count     uint64
+	sum       float64 // seconds
+}
+
+func newRPCMetrics() *rpcMetrics {
+	return &rpcMetrics{methods: make(map[string]*methodMetrics)}
+}
+
+func (m *rpcMetrics) method(name string, streaming bool) *methodMetrics {
+	mm, ok := m.methods[name]
+	if !ok {
+		mm = &methodMetrics{
+			streaming: streaming,
+			handled:   make(map[codes.Code]uint64),
+			buckets:   make([]uint64, len(latencyBuckets)),
+		}
+		m.methods[name] = mm
+	}
+	return mm
+}
+
+func (m *rpcMetrics) start(method string, streaming bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.method(method, streaming).started++
+}
+
+func (m *rpcMetrics) done(method string, streaming bool, code codes.Code, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mm := m.method(method, streaming)
+	mm.handled[code]++
+	secs := d.Seconds()
+	if i, _ := slices.BinarySearch(latencyBuckets, secs); i < len(latencyBuckets) {
+		mm.buckets[i]++
+	}
+	mm.count++
+	mm.sum += secs
+}
+
+func (m *rpcMetrics) unary(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	m.start(info.FullMethod, false)
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	m.done(info.FullMethod, false, status.Code(err), time.Since(start))
+	return resp, err
+}
+
+func (m *rpcMetrics) stream(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	m.start(info.FullMethod, true)
+	start := time.Now()
+	err := handler(srv, ss)
+	m.done(info.FullMethod, true, status.Code(err), time.Since(start))
+	return err
+}
+
+// ServeHTTP writes all metrics, e.g.
+//
+//	grpc_server_handled_total{grpc_service="user.UserService",grpc_method="GetUser",grpc_type="unary",grpc_code="NotFound"} 3
+func (m *rpcMetrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.methods))
+	for name := range m.methods {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var b strings.Builder
+	b.WriteString("# HELP grpc_server_started_total RPCs started on the server.\n")
+	b.WriteString("# TYPE grpc_server_started_total counter\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "grpc_server_started_total{%s} %d\n", methodLabels(name, m.methods[name]), m.methods[name].started)
+	}
+
+	b.WriteString("# HELP grpc_server_handled_total RPCs completed on the server, by status code.\n")
+	b.WriteString("# TYPE grpc_server_handled_total counter\n")
+	for _, name := range names {
+		mm := m.methods[name]
+		codeList := make([]codes.Code, 0, len(mm.handled))
+		for c := range mm.handled {
+			codeList = append(codeList, c)
+		}
+		slices.Sort(codeList)
+		for _, c := range codeList {
+			fmt.Fprintf(&b, "grpc_server_handled_total{%s,grpc_code=%q} %d\n", methodLabels(name, mm), c.String(), mm.handled[c])
+		}
+	}
+
+	b.WriteString("# HELP grpc_server_handling_seconds Time from the start of an RPC until the handler returned.\n")
+	b.WriteString("# TYPE grpc_server_handling_seconds histogram\n")
+	for _, name := range names {
+		mm := m.methods[name]
+		labels := methodLabels(name, mm)
+		var cumulative uint64
+		for i, le := range latencyBuckets {
+			cumulative += mm.buckets[i]
+			fmt.Fprintf(&b, "grpc_server_handling_seconds_bucket{%s,le=%q} %d\n", labels, strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(&b, "grpc_server_handling_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, mm.count)
+		fmt.Fprintf(&b, "grpc_server_handling_seconds_sum{%s} %g\n", labels, mm.sum)
+		fmt.Fprintf(&b, "grpc_server_handling_seconds_count{%s} %d\n", labels, mm.count)
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write([]byte(b.String()))
+}
+
+// methodLabels splits "/user.UserService/GetUser" into service and method.
+func methodLabels(fullMethod string, mm *methodMetrics) string {
+	service, method, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	typ := "unary"
+	if mm.streaming {
+		typ = "stream"
+	}
+	return fmt.Sprintf("grpc_service=%q,grpc_method=%q,grpc_type=%q", service, method, typ)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/base64"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	userpb "github.com/cristianmanoliu/learning-golang/grpc-playground/proto_gen/proto"
+)
+
+const (
+	// watchBufferSize is how many events a WatchUsers stream may fall behind
+	// before it is cut off. The client can reconnect with its last resume
+	// token and pick up from the history below.
+	watchBufferSize = 64
+	// eventHistorySize is how many past events are kept for resuming.
+	eventHistorySize = 1024
+)
+
+// eventLog numbers every change to the users and fans it out to watchers.
+// All of its methods must be called with userServer.mu held, so the order of
+// events is the order in which the store changed, and a watcher registered
+// together with a snapshot misses nothing and sees nothing twice.
+type eventLog struct {
+	// epoch identifies this server process. Resume tokens from another one
+	// (e.g. before a restart) refer to a history that no longer exists.
+	epoch    string
+	seq      uint64
+	history  []loggedEvent // the last eventHistorySize events, oldest first
+	watchers map[*watcher]struct{}
+}
+
+type loggedEvent struct {
+	seq   uint64
+	event *userpb.UserEvent
+}
+
+// watcher is one WatchUsers stream. publish never blocks on it: when events
+// is full, the watcher is dropped and dropped closed.
+type watcher struct {
+	events  chan *userpb.UserEvent
+	dropped chan struct{}
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{
+		epoch:    uuid.NewString(),
+		watchers: make(map[*watcher]struct{}),
+	}
+}
+
+// publish records a change to u and hands it to every watcher.
+func (l *eventLog) publish(typ userpb.UserEvent_Type, u *userpb.User) {
+	l.seq++
+	ev := &userpb.UserEvent{Type: typ, User: u, ResumeToken: l.token(l.seq)}
+
+	if len(l.history) == eventHistorySize {
+		l.history = append(l.history[:0], l.history[1:]...)
+	}
+	l.history = append(l.history, loggedEvent{seq: l.seq, event: ev})
+
+	for w := range l.watchers {
+		select {
+		case w.events <- ev:
+		default:
+			// A slow client must not hold up writers or grow our memory.
+			delete(l.watchers, w)
+			close(w.dropped)
+		}
+	}
+}
+
+// subscribe registers a watcher that receives every event after the
+// current one.
+func (l *eventLog) subscribe() *watcher {
+	w := &watcher{
+		events:  make(chan *userpb.UserEvent, watchBufferSize),
+		dropped: make(chan struct{}),
+	}
+	l.watchers[w] = struct{}{}
+	return w
+}
+
+func (l *eventLog) unsubscribe(w *watcher) {
+	delete(l.watchers, w)
+}
+
+// since returns the events after the one that carried token.
+func (l *eventLog) since(token string) ([]*userpb.UserEvent, error) {
+	epoch, seq, ok := parseResumeToken(token)
+	if !ok || (epoch == l.epoch && seq > l.seq) {
+		var v fieldViolations
+		v.add("resume_token", "is not a token returned by WatchUsers")
+		return nil, v.err()
+	}
+	// The history must still hold the event right after seq, unless seq is
+	// the latest event and there is nothing to replay.
+	if epoch != l.epoch || (seq < l.seq && l.history[0].seq > seq+1) {
+		return nil, status.Error(codes.OutOfRange, "resume token has expired; watch again with send_snapshot")
+	}
+
+	var events []*userpb.UserEvent
+	for _, le := range l.history {
+		if le.seq > seq {
+			events = append(events, le.event)
+		}
+	}
+	return events, nil
+}
+
+// token encodes a position in the log. It is opaque to clients.
+func (l *eventLog) token(seq uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(l.epoch + "/" + strconv.FormatUint(seq, 10)))
+}
+
+func parseResumeToken(token string) (epoch string, seq uint64, ok bool) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", 0, false
+	}
+	epoch, num, found := strings.Cut(string(raw), "/")
+	if !found {
+		return "", 0, false
+	}
+	seq, err = strconv.ParseUint(num, 10, 64)
+	return epoch, seq, err == nil
+}
+
+func (s *userServer) WatchUsers(req *userpb.WatchUsersRequest, stream grpc.ServerStreamingServer[userpb.UserEvent]) error {
+	if req.GetSendSnapshot() && req.GetResumeToken() != "" {
+		var v fieldViolations
+		v.add("resume_token", "cannot be combined with send_snapshot")
+		return v.err()
+	}
+
+	// Take the backlog and subscribe in one critical section, so no change
+	// slips in between the two.
+	var backlog []*userpb.UserEvent
+	s.mu.Lock()
+	if req.GetResumeToken() != "" {
+		events, err := s.events.since(req.GetResumeToken())
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		backlog = events
+	} else if req.GetSendSnapshot() {
+		for _, id := range s.order {
+			backlog = append(backlog, &userpb.UserEvent{Type: userpb.UserEvent_SNAPSHOT, User: s.users[id]})
+		}
+		backlog = append(backlog, &userpb.UserEvent{
+			Type:        userpb.UserEvent_SNAPSHOT_COMPLETE,
+			ResumeToken: s.events.token(s.events.seq),
+		})
+	}
+	w := s.events.subscribe()
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.events.unsubscribe(w)
+		s.mu.Unlock()
+	}()
+
+	log.Printf("WatchUsers: started, backlog=%d\n", len(backlog))
+
+	// Send from another goroutine: when the client stops reading, Send blocks
+	// until the stream ends, and only returning from this handler ends it.
+	sent := make(chan error, 1)
+	go func() { sent <- sendEvents(stream, backlog, w) }()
+
+	select {
+	case err := <-sent:
+		return err
+	case <-w.dropped:
+		log.Printf("WatchUsers: client fell %d events behind, closing\n", watchBufferSize)
+		return status.Error(codes.ResourceExhausted, "watcher fell too far behind; resume with the last token received")
+	}
+}
+
+// sendEvents streams backlog and then live events until the client goes
+// away or w is dropped.
+func sendEvents(stream grpc.ServerStreamingServer[userpb.UserEvent], backlog []*userpb.UserEvent, w *watcher) error {
+	for _, ev := range backlog {
+		if err := stream.Send(ev); err != nil {
+			return err
+		}
+	}
+
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("WatchUsers: client went away: %v\n", ctx.Err())
+			return status.FromContextError(ctx.Err()).Err()
+		case <-w.dropped:
+			return nil // WatchUsers reports it
+		case ev := <-w.events:
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
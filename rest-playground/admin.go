@@ -0,0 +1,425 @@
+package main
+
+import (
+	"crypto/subtle"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	adminPageSize = 20
+
+	csrfCookieName  = "csrf"
+	csrfFieldName   = "csrf_token"
+	flashCookieName = "flash"
+)
+
+//go:embed templates/admin/*.html
+var adminTemplatesFS embed.FS
+
+// adminUI is a small server-rendered UI for support staff. It goes through
+// the same userStore methods as the JSON API, so both always agree. Like the
+// API, every page works on the request tenant only.
+type adminUI struct {
+	tenants *tenantRegistry
+	// admins holds adminKey(tenant, email) of everyone who may use the UI.
+	// It is replaced as a whole when the config changes.
+	admins atomic.Pointer[map[string]bool]
+	pages  map[string]*template.Template
+}
+
+// flash is a one-shot message shown on the page after a redirect.
+type flash struct {
+	Kind    string // "ok" or "error"
+	Message string
+}
+
+// adminPage is what every template gets. Data carries the page-specific part.
+type adminPage struct {
+	Title  string
+	Tenant string
+	CSRF   string
+	Flash  *flash
+	Me     *User
+	Data   any
+}
+
+type userListData struct {
+	Users    []User
+	Query    string
+	Total    int
+	Page     int
+	Pages    int
+	PrevPage int // 0 when there is no previous page
+	NextPage int // 0 when there is no next page
+	// ReturnQuery brings the admin back to this search and page after a delete.
+	ReturnQuery string
+}
+
+type userFormData struct {
+	User  User
+	IsNew bool
+	Error string
+}
+
+type loginData struct {
+	Tenant string
+	Email  string
+	Error  string
+}
+
+func newAdminUI(tenants *tenantRegistry, adminEmails []string) (*adminUI, error) {
+	ui := &adminUI{
+		tenants: tenants,
+		pages:   make(map[string]*template.Template),
+	}
+	ui.setAdmins(adminEmails)
+
+	// Every page is parsed together with the layout, which calls {{template "content" .}}.
+	for _, name := range []string{"users.html", "user_form.html", "login.html"} {
+		t, err := template.ParseFS(adminTemplatesFS, "templates/admin/layout.html", "templates/admin/"+name)
+		if err != nil {
+			return nil, err
+		}
+		ui.pages[name] = t
+	}
+	return ui, nil
+}
+
+// setAdmins replaces the list of emails allowed into the UI; see
+// splitAdminEmail for the format.
+func (ui *adminUI) setAdmins(entries []string) {
+	admins := make(map[string]bool)
+	for _, entry := range entries {
+		if tenantID, email := splitAdminEmail(entry); email != "" {
+			admins[adminKey(tenantID, email)] = true
+		}
+	}
+	ui.admins.Store(&admins)
+}
+
+// splitAdminEmail splits an admin list entry, "acme:ana@example.com", into
+// tenant and normalized email. Entries without a tenant are admins of the
+// default tenant only, so being an admin never carries over to another
+// tenant, least of all one that anybody can create by naming it.
+func splitAdminEmail(entry string) (tenantID, email string) {
+	tenantID, email, ok := strings.Cut(entry, ":")
+	if !ok {
+		tenantID, email = defaultTenantID, entry
+	}
+	return strings.TrimSpace(tenantID), normalizeEmail(email)
+}
+
+func adminKey(tenantID, email string) string {
+	return tenantID + ":" + email
+}
+
+// isAdmin reports whether u may use the UI in the tenant. The email must be
+// verified: otherwise anyone could register the admin's address first.
+func (ui *adminUI) isAdmin(tenantID string, u User) bool {
+	return u.EmailVerified && (*ui.admins.Load())[adminKey(tenantID, normalizeEmail(u.Email))]
+}
+
+func registerAdminRoutes(mux *http.ServeMux, ui *adminUI) {
+	mux.HandleFunc("GET /admin", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+	})
+	mux.HandleFunc("GET /admin/login", ui.handleLoginForm)
+	mux.HandleFunc("POST /admin/login", ui.csrfProtect(ui.handleLogin))
+	mux.HandleFunc("POST /admin/logout", ui.csrfProtect(ui.handleLogout))
+
+	mux.HandleFunc("GET /admin/users", ui.requireAdmin(ui.handleList))
+	mux.HandleFunc("GET /admin/users/new", ui.requireAdmin(ui.handleNewForm))
+	mux.HandleFunc("POST /admin/users", ui.requireAdmin(ui.csrfProtect(ui.handleCreate)))
+	mux.HandleFunc("GET /admin/users/{id}/edit", ui.requireAdmin(ui.handleEditForm))
+	mux.HandleFunc("POST /admin/users/{id}", ui.requireAdmin(ui.csrfProtect(ui.handleUpdate)))
+	mux.HandleFunc("POST /admin/users/{id}/delete", ui.requireAdmin(ui.csrfProtect(ui.handleDelete)))
+}
+
+// requireAdmin sends visitors without a session to the login page and
+// refuses logged-in users that aren't on the admin list.
+func (ui *adminUI) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := ui.currentAdmin(r); !ok {
+			if _, loggedIn := ui.currentUser(r); loggedIn {
+				http.Error(w, "forbidden", http.StatusForbidden)
+				return
+			}
+			http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (ui *adminUI) currentUser(r *http.Request) (User, bool) {
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return User{}, false
+	}
+	userID, ok := authFor(r).authenticate(c.Value)
+	if !ok {
+		return User{}, false
+	}
+	u, err := storeFor(r).getUser(userID)
+	return u, err == nil
+}
+
+func (ui *adminUI) currentAdmin(r *http.Request) (User, bool) {
+	u, ok := ui.currentUser(r)
+	if !ok || !ui.isAdmin(tenantFrom(r.Context()).id, u) {
+		return User{}, false
+	}
+	return u, true
+}
+
+// csrfToken returns the double-submit token for this browser, issuing a new
+// cookie if there isn't one yet.
+func csrfToken(w http.ResponseWriter, r *http.Request) string {
+	if c, err := r.Cookie(csrfCookieName); err == nil && c.Value != "" {
+		return c.Value
+	}
+	token, err := newToken()
+	if err != nil {
+		log.Printf("csrf token: %v", err)
+		return ""
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/admin",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return token
+}
+
+// csrfProtect implements the double-submit cookie pattern: the form field
+// must match the csrf cookie. Another site can make the browser send the
+// cookie, but it can't read it to fill in the field.
+func (ui *adminUI) csrfProtect(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		c, err := r.Cookie(csrfCookieName)
+		field := r.PostFormValue(csrfFieldName)
+		if err != nil || c.Value == "" || subtle.ConstantTimeCompare([]byte(c.Value), []byte(field)) != 1 {
+			http.Error(w, "invalid CSRF token", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func setFlash(w http.ResponseWriter, kind, message string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookieName,
+		Value:    base64.RawURLEncoding.EncodeToString([]byte(kind + "\n" + message)),
+		Path:     "/admin",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// popFlash reads the flash message, if any, and clears it so it shows only once.
+func popFlash(w http.ResponseWriter, r *http.Request) *flash {
+	c, err := r.Cookie(flashCookieName)
+	if err != nil {
+		return nil
+	}
+	http.SetCookie(w, &http.Cookie{Name: flashCookieName, Path: "/admin", MaxAge: -1})
+
+	raw, err := base64.RawURLEncoding.DecodeString(c.Value)
+	if err != nil {
+		return nil
+	}
+	kind, message, ok := strings.Cut(string(raw), "\n")
+	if !ok {
+		return nil
+	}
+	return &flash{Kind: kind, Message: message}
+}
+
+func (ui *adminUI) render(w http.ResponseWriter, r *http.Request, status int, name, title string, data any) {
+	page := adminPage{
+		Title:  title,
+		Tenant: tenantFrom(r.Context()).id,
+		CSRF:   csrfToken(w, r),
+		Flash:  popFlash(w, r),
+		Data:   data,
+	}
+	if u, ok := ui.currentAdmin(r); ok {
+		page.Me = &u
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := ui.pages[name].ExecuteTemplate(w, "layout", page); err != nil {
+		log.Printf("render %s: %v", name, err)
+	}
+}
+
+func (ui *adminUI) handleLoginForm(w http.ResponseWriter, r *http.Request) {
+	ui.render(w, r, http.StatusOK, "login.html", "Sign in", loginData{Tenant: tenantFrom(r.Context()).id})
+}
+
+// handleLogin signs into the tenant named in the form. Browsers can't send
+// X-Tenant-ID, so the form field is how they pick one; afterwards the
+// session token's tenant claim keeps them there.
+func (ui *adminUI) handleLogin(w http.ResponseWriter, r *http.Request) {
+	email := r.PostFormValue("email")
+	tenantID := strings.TrimSpace(r.PostFormValue("tenant"))
+	if tenantID == "" {
+		tenantID = tenantFrom(r.Context()).id
+	}
+	data := loginData{Tenant: tenantID, Email: email}
+
+	t, err := ui.tenants.get(tenantID)
+	if err != nil {
+		data.Error = "Unknown tenant."
+		ui.render(w, r, http.StatusUnauthorized, "login.html", "Sign in", data)
+		return
+	}
+	u, token, _, err := t.auth.login(email, r.PostFormValue("password"))
+	if err != nil {
+		data.Error = "Invalid email or password."
+		if errors.Is(err, errAccountLocked) {
+			data.Error = "Too many failed attempts. Try again later."
+		}
+		ui.render(w, r, http.StatusUnauthorized, "login.html", "Sign in", data)
+		return
+	}
+	if !ui.isAdmin(t.id, u) {
+		t.auth.revoke(token)
+		data.Error = "This account is not an admin."
+		ui.render(w, r, http.StatusForbidden, "login.html", "Sign in", data)
+		return
+	}
+
+	setSessionCookie(w, token)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (ui *adminUI) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookieName); err == nil {
+		authFor(r).revoke(c.Value)
+	}
+	clearSessionCookie(w)
+	setFlash(w, "ok", "Signed out.")
+	http.Redirect(w, r, "/admin/login", http.StatusSeeOther)
+}
+
+func (ui *adminUI) handleList(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	users, total := storeFor(r).findUsers(query, (page-1)*adminPageSize, adminPageSize)
+	pages := max(1, (total+adminPageSize-1)/adminPageSize)
+
+	data := userListData{
+		Users: users,
+		Query: query,
+		Total: total,
+		Page:  page,
+		Pages: pages,
+	}
+	data.ReturnQuery = "?" + url.Values{"q": {query}, "page": {strconv.Itoa(page)}}.Encode()
+	if page > 1 {
+		data.PrevPage = min(page-1, pages)
+	}
+	if page < pages {
+		data.NextPage = page + 1
+	}
+	ui.render(w, r, http.StatusOK, "users.html", "Users", data)
+}
+
+func (ui *adminUI) handleNewForm(w http.ResponseWriter, r *http.Request) {
+	ui.render(w, r, http.StatusOK, "user_form.html", "New user", userFormData{IsNew: true})
+}
+
+func (ui *adminUI) handleCreate(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if name == "" {
+		ui.render(w, r, http.StatusUnprocessableEntity, "user_form.html", "New user",
+			userFormData{IsNew: true, Error: "Name is required."})
+		return
+	}
+
+	u, err := storeFor(r).addUser(name)
+	if err != nil {
+		ui.render(w, r, http.StatusUnprocessableEntity, "user_form.html", "New user",
+			userFormData{User: User{Name: name}, IsNew: true, Error: "Could not create user: " + err.Error() + "."})
+		return
+	}
+	setFlash(w, "ok", "Created user #"+strconv.Itoa(u.ID)+".")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (ui *adminUI) handleEditForm(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	u, err := storeFor(r).getUser(id)
+	if err != nil {
+		setFlash(w, "error", "User not found.")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+	ui.render(w, r, http.StatusOK, "user_form.html", "Edit user", userFormData{User: u})
+}
+
+func (ui *adminUI) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(r.PostFormValue("name"))
+	if name == "" {
+		u, _ := storeFor(r).getUser(id)
+		ui.render(w, r, http.StatusUnprocessableEntity, "user_form.html", "Edit user",
+			userFormData{User: u, Error: "Name is required."})
+		return
+	}
+
+	if _, err := storeFor(r).updateUser(id, name); err != nil {
+		setFlash(w, "error", "User not found.")
+	} else {
+		setFlash(w, "ok", "Saved user #"+strconv.Itoa(id)+".")
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+func (ui *adminUI) handleDelete(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	if err := storeFor(r).deleteUser(id); err != nil {
+		setFlash(w, "error", "User not found.")
+	} else {
+		setFlash(w, "ok", "Deleted user #"+strconv.Itoa(id)+".")
+	}
+
+	// Go back to the list the admin came from, keeping search and page.
+	target := "/admin/users"
+	if q := r.PostFormValue("return"); strings.HasPrefix(q, "?") {
+		if v, err := url.ParseQuery(q[1:]); err == nil {
+			target += "?" + v.Encode()
+		}
+	}
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
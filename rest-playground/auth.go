@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionCookieName = "session"
+
+	// sessionIdleTimeout is the sliding expiry: every authenticated request
+	// pushes it forward. sessionMaxAge is the absolute cap that nothing extends.
+	sessionIdleTimeout = 30 * time.Minute
+	sessionMaxAge      = 12 * time.Hour
+
+	// After maxLoginFailures failed logins within lockoutWindow the account
+	// is locked for lockoutDuration.
+	maxLoginFailures = 5
+	lockoutWindow    = 15 * time.Minute
+	lockoutDuration  = 15 * time.Minute
+
+	minPasswordLen = 8
+	// bcrypt silently ignores everything past 72 bytes, so reject longer input.
+	maxPasswordLen = 72
+)
+
+var (
+	errEmailTaken         = errors.New("email already registered")
+	errInvalidCredentials = errors.New("invalid email or password")
+	errAccountLocked      = errors.New("account temporarily locked")
+)
+
+// credential is what we keep per registered email. The password itself is never stored.
+type credential struct {
+	userID       int
+	passwordHash []byte
+}
+
+// session is a server-side login session. The token handed to the browser is
+// only stored as a SHA-256 hash, so a leaked session table can't be replayed.
+type session struct {
+	userID     int
+	createdAt  time.Time
+	lastSeenAt time.Time
+}
+
+// loginFailures tracks failed logins for one email.
+type loginFailures struct {
+	count       int
+	firstFailAt time.Time
+	lockedUntil time.Time
+}
+
+// authService owns credentials, sessions and lockout state for one tenant.
+// Users themselves still live in the tenant's userStore.
+type authService struct {
+	tenantID string
+	store    *userStore
+	tokens   *tokenStore
+	mailer   Mailer
+	// publicURL is the externally visible base URL used in emailed links.
+	publicURL string
+
+	mu          sync.Mutex
+	credentials map[string]credential // keyed by normalized email
+	sessions    map[string]*session   // keyed by hashed token
+	failures    map[string]*loginFailures
+}
+
+// dummyHash is compared against when the email is unknown, so a login for a
+// missing account costs as much time as one with a wrong password. It is
+// computed once and shared by every tenant.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+func newAuthService(tenantID string, store *userStore, mailer Mailer, publicURL string) *authService {
+	return &authService{
+		tenantID:    tenantID,
+		store:       store,
+		tokens:      newTokenStore(),
+		mailer:      mailer,
+		publicURL:   strings.TrimSuffix(publicURL, "/"),
+		credentials: make(map[string]credential),
+		sessions:    make(map[string]*session),
+		failures:    make(map[string]*loginFailures),
+	}
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// register creates a user with a bcrypt-hashed password.
+func (a *authService) register(name, email, password string) (User, error) {
+	// Hash outside the lock: bcrypt is deliberately slow.
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	email = normalizeEmail(email)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if c, ok := a.credentials[email]; ok {
+		// An email only stays taken while its user still exists.
+		if _, err := a.store.getUser(c.userID); err == nil {
+			return User{}, errEmailTaken
+		}
+	}
+	u, err := a.store.addUserWithEmail(name, email)
+	if err != nil {
+		return User{}, err
+	}
+	a.credentials[email] = credential{userID: u.ID, passwordHash: hash}
+	return u, nil
+}
+
+// login checks the password and opens a new session, returning its raw token.
+func (a *authService) login(email, password string) (User, string, time.Duration, error) {
+	email = normalizeEmail(email)
+	now := time.Now()
+
+	a.mu.Lock()
+	if wait := a.lockedFor(email, now); wait > 0 {
+		a.mu.Unlock()
+		return User{}, "", wait, errAccountLocked
+	}
+	// Count the attempt as failed before bcrypt runs, and take that back if
+	// it succeeds. Counting afterwards would let parallel guesses all pass
+	// the check above before any of them is recorded.
+	a.recordFailure(email, now)
+	cred, known := a.credentials[email]
+	a.mu.Unlock()
+
+	hash := dummyHash()
+	if known {
+		hash = cred.passwordHash
+	}
+	// CompareHashAndPassword does a constant-time comparison of the derived keys.
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+
+	var u User
+	if err == nil && known {
+		// The user may have been deleted through the users API since registering.
+		u, err = a.store.getUser(cred.userID)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err != nil || !known {
+		return User{}, "", 0, errInvalidCredentials
+	}
+	delete(a.failures, email)
+
+	// The tenant prefix is the token's tenant claim: tenantMiddleware reads it
+	// to route the request before any session lookup happens. It is not
+	// trusted on its own; the token must still exist in this tenant's sessions.
+	random, err := newToken()
+	if err != nil {
+		return User{}, "", 0, err
+	}
+	token := a.tenantID + "." + random
+	a.sessions[hashToken(token)] = &session{
+		userID:     u.ID,
+		createdAt:  now,
+		lastSeenAt: now,
+	}
+	return u, token, 0, nil
+}
+
+// lockedFor returns how much longer the email is locked out, or 0.
+// Callers must hold a.mu.
+func (a *authService) lockedFor(email string, now time.Time) time.Duration {
+	f, ok := a.failures[email]
+	if !ok || now.After(f.lockedUntil) {
+		return 0
+	}
+	return f.lockedUntil.Sub(now)
+}
+
+// recordFailure counts a failed login and locks the email once the limit is hit.
+// Callers must hold a.mu.
+func (a *authService) recordFailure(email string, now time.Time) {
+	f, ok := a.failures[email]
+	if !ok || now.Sub(f.firstFailAt) > lockoutWindow {
+		f = &loginFailures{firstFailAt: now}
+		a.failures[email] = f
+	}
+	f.count++
+	if f.count >= maxLoginFailures {
+		f.lockedUntil = now.Add(lockoutDuration)
+		f.count = 0
+		f.firstFailAt = now
+	}
+}
+
+// authenticate resolves a raw session token to a user ID, sliding the idle
+// expiry forward. Expired sessions are dropped on sight.
+func (a *authService) authenticate(token string) (int, bool) {
+	key := hashToken(token)
+	now := time.Now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s, ok := a.sessions[key]
+	if !ok {
+		return 0, false
+	}
+	if now.Sub(s.lastSeenAt) > sessionIdleTimeout || now.Sub(s.createdAt) > sessionMaxAge {
+		delete(a.sessions, key)
+		return 0, false
+	}
+	s.lastSeenAt = now
+	return s.userID, true
+}
+
+// revoke ends a single session.
+func (a *authService) revoke(token string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.sessions, hashToken(token))
+}
+
+// revokeUser ends every session belonging to a user.
+func (a *authService) revokeUser(userID int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for key, s := range a.sessions {
+		if s.userID == userID {
+			delete(a.sessions, key)
+		}
+	}
+}
+
+// purgeExpired drops sessions and lockout records that have run out.
+// authenticate already drops an expired session when it is presented; this
+// catches the ones nobody comes back with.
+func (a *authService) purgeExpired(now time.Time) (sessions, failures int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for key, s := range a.sessions {
+		if now.Sub(s.lastSeenAt) > sessionIdleTimeout || now.Sub(s.createdAt) > sessionMaxAge {
+			delete(a.sessions, key)
+			sessions++
+		}
+	}
+	for email, f := range a.failures {
+		if now.After(f.lockedUntil) && now.Sub(f.firstFailAt) > lockoutWindow {
+			delete(a.failures, email)
+			failures++
+		}
+	}
+	return sessions, failures
+}
+
+// newToken returns 32 bytes of crypto/rand, base64url-encoded.
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+type ctxKey int
+
+const (
+	userIDKey ctxKey = iota
+	clientIdentityKey
+	tenantKey
+)
+
+// sessionToken returns the raw session token of a request. Browsers send it
+// in the session cookie; command-line clients such as usersctl send it as
+// "Authorization: Bearer <token>", which wins when both are present.
+func sessionToken(r *http.Request) (string, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok && token != "" {
+		return token, true
+	}
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil || c.Value == "" {
+		return "", false
+	}
+	return c.Value, true
+}
+
+// requireSession only lets requests with a valid session token for the
+// request tenant through, and puts the user ID into the request context.
+func requireSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := sessionToken(r)
+		if !ok {
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+		userID, ok := authFor(r).authenticate(token)
+		if !ok {
+			http.Error(w, "unauthenticated", http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(r.Context(), userIDKey, userID)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// sessionUserID returns the user ID put into the context by requireSession.
+func sessionUserID(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(userIDKey).(int)
+	return id, ok
+}
+
+// tenantAuth turns an authService handler method into a handler that runs
+// on the request tenant's service, e.g. tenantAuth((*authService).handleLogin).
+func tenantAuth(h func(*authService, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(authFor(r), w, r)
+	}
+}
+
+func registerAuthRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /auth/register", tenantAuth((*authService).handleRegister))
+	mux.HandleFunc("POST /auth/login", tenantAuth((*authService).handleLogin))
+	mux.HandleFunc("POST /auth/logout", requireSession(tenantAuth((*authService).handleLogout)))
+	mux.HandleFunc("GET /auth/me", requireSession(tenantAuth((*authService).handleMe)))
+}
+
+type registerRequest struct {
+	Name     string `json:"name"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type loginRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+func (a *authService) handleRegister(w http.ResponseWriter, r *http.Request) {
+	var req registerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	if !validEmail(req.Email) {
+		http.Error(w, "valid email is required", http.StatusBadRequest)
+		return
+	}
+	if msg := checkPassword(req.Password); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	u, err := a.register(req.Name, req.Email, req.Password)
+	if errors.Is(err, errEmailTaken) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if errors.Is(err, errQuotaExceeded) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	a.sendVerification(u)
+	respondJSON(w, http.StatusCreated, u)
+}
+
+// validEmail accepts a bare address such as ana@example.com: no display name,
+// and nothing that could break out of an email header.
+func validEmail(email string) bool {
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// checkPassword returns a validation message, or "" if the password is acceptable.
+func checkPassword(password string) string {
+	switch {
+	case len(password) < minPasswordLen:
+		return "password must be at least " + strconv.Itoa(minPasswordLen) + " characters"
+	case len(password) > maxPasswordLen:
+		return "password must be at most " + strconv.Itoa(maxPasswordLen) + " bytes"
+	}
+	return ""
+}
+
+func (a *authService) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req loginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	u, token, wait, err := a.login(req.Email, req.Password)
+	switch {
+	case errors.Is(err, errAccountLocked):
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	case errors.Is(err, errInvalidCredentials):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case err != nil:
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	setSessionCookie(w, token)
+	respondJSON(w, http.StatusOK, u)
+}
+
+func (a *authService) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if token, ok := sessionToken(r); ok {
+		a.revoke(token)
+	}
+	clearSessionCookie(w)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func setSessionCookie(w http.ResponseWriter, token string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     "/",
+		MaxAge:   int(sessionMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	// MaxAge < 0 tells the browser to drop the cookie right away.
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func (a *authService) handleMe(w http.ResponseWriter, r *http.Request) {
+	userID, _ := sessionUserID(r.Context())
+	u, err := a.store.getUser(userID)
+	if err != nil {
+		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		return
+	}
+	respondJSON(w, http.StatusOK, u)
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // registers the GIF decoder with image.Decode
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	maxAvatarBytes = 5 << 20 // 5 MiB
+	// maxAvatarPixels guards against decompression bombs: a tiny PNG can
+	// claim to be 50000x50000 and eat gigabytes once decoded.
+	maxAvatarPixels = 4096 * 4096
+	thumbnailSize   = 128
+
+	// Versioned URLs (?v=...) never change content, so browsers may cache them forever.
+	immutableCacheControl = "public, max-age=31536000, immutable"
+	// Unversioned URLs have to be revalidated, since a new upload replaces them.
+	revalidateCacheControl = "public, no-cache"
+)
+
+// allowedAvatarTypes maps sniffed MIME types to the file extension we store them under.
+var allowedAvatarTypes = map[string]string{
+	"image/png":  "png",
+	"image/jpeg": "jpg",
+	"image/gif":  "gif",
+}
+
+// Avatar is the public part of a user's profile picture.
+type Avatar struct {
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	UpdatedAt    time.Time `json:"updated_at"`
+
+	// tenant is the owning tenant; blob keys are namespaced by it.
+	tenant string
+	// version is a content hash; it doubles as ETag and cache-busting query parameter.
+	version     string
+	contentType string
+	ext         string
+	thumbType   string
+	thumbExt    string
+}
+
+func (a *Avatar) originalKey(userID int) string {
+	return fmt.Sprintf("tenants/%s/avatars/%d/original.%s", a.tenant, userID, a.ext)
+}
+
+func (a *Avatar) thumbnailKey(userID int) string {
+	return fmt.Sprintf("tenants/%s/avatars/%d/thumbnail.%s", a.tenant, userID, a.thumbExt)
+}
+
+// setAvatar attaches avatar metadata to a user and returns the previous one, if any.
+func (s *userStore) setAvatar(id int, a *Avatar) (*Avatar, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.userIndex(id)
+	if i < 0 {
+		return nil, errUserNotFound
+	}
+	prev := s.users[i].Avatar
+	s.users[i].Avatar = a
+	s.touch()
+	return prev, nil
+}
+
+// avatarService handles uploads and serves avatars out of a BlobStore.
+// Users come from the request tenant's store.
+type avatarService struct {
+	blobs BlobStore
+}
+
+// blobTimeout bounds blob cleanup that runs after the request that caused it
+// has been answered or was cancelled.
+const blobTimeout = 10 * time.Second
+
+// Only users themselves may change their avatar; anyone may look at it.
+func registerAvatarRoutes(mux *http.ServeMux, avatars *avatarService) {
+	mux.HandleFunc("PUT /users/{id}/avatar", requireSession(avatars.handleUpload))
+	mux.HandleFunc("DELETE /users/{id}/avatar", requireSession(avatars.handleDelete))
+	mux.HandleFunc("GET /users/{id}/avatar", func(w http.ResponseWriter, r *http.Request) {
+		avatars.serve(w, r, false)
+	})
+	mux.HandleFunc("GET /users/{id}/avatar/thumbnail", func(w http.ResponseWriter, r *http.Request) {
+		avatars.serve(w, r, true)
+	})
+}
+
+// handleUpload expects a multipart/form-data body with the image in an "avatar" field.
+func (s *avatarService) handleUpload(w http.ResponseWriter, r *http.Request) {
+	id, ok := ownAvatarID(w, r)
+	if !ok {
+		return
+	}
+	store := storeFor(r)
+	if _, err := store.getUser(id); err != nil {
+		respondStoreError(w, err)
+		return
+	}
+
+	// Leave some room for multipart headers and boundaries on top of the file itself.
+	r.Body = http.MaxBytesReader(w, r.Body, maxAvatarBytes+64<<10)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, "expected multipart/form-data body", http.StatusBadRequest)
+		return
+	}
+
+	var data []byte
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			respondBodyError(w, err)
+			return
+		}
+		if part.FormName() != "avatar" {
+			continue
+		}
+		// Read one byte past the limit to tell "exactly at the limit" from "too big".
+		data, err = io.ReadAll(io.LimitReader(part, maxAvatarBytes+1))
+		if err != nil {
+			respondBodyError(w, err)
+			return
+		}
+		break
+	}
+	if data == nil {
+		http.Error(w, `missing "avatar" file field`, http.StatusBadRequest)
+		return
+	}
+	if len(data) > maxAvatarBytes {
+		http.Error(w, "avatar must be at most "+strconv.Itoa(maxAvatarBytes>>20)+" MiB", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// Never trust the client's Content-Type: sniff the actual bytes.
+	contentType := http.DetectContentType(data)
+	ext, ok := allowedAvatarTypes[contentType]
+	if !ok {
+		http.Error(w, "avatar must be a PNG, JPEG or GIF image", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, "could not decode image", http.StatusUnprocessableEntity)
+		return
+	}
+	if cfg.Width*cfg.Height > maxAvatarPixels {
+		http.Error(w, "image dimensions too large", http.StatusUnprocessableEntity)
+		return
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		http.Error(w, "could not decode image", http.StatusUnprocessableEntity)
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	a := &Avatar{
+		UpdatedAt:   time.Now().UTC(),
+		tenant:      tenantFrom(r.Context()).id,
+		version:     hex.EncodeToString(sum[:8]),
+		contentType: contentType,
+		ext:         ext,
+		thumbType:   "image/png",
+		thumbExt:    "png",
+	}
+	// Photos compress far better as JPEG; keep PNG for everything else so
+	// transparency survives.
+	if contentType == "image/jpeg" {
+		a.thumbType, a.thumbExt = "image/jpeg", "jpg"
+	}
+	a.URL = fmt.Sprintf("/users/%d/avatar?v=%s", id, a.version)
+	a.ThumbnailURL = fmt.Sprintf("/users/%d/avatar/thumbnail?v=%s", id, a.version)
+
+	var thumb bytes.Buffer
+	if err := encodeThumbnail(&thumb, img, a.thumbType); err != nil {
+		log.Printf("encode thumbnail for user %d: %v", id, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+	if err := s.blobs.Put(ctx, a.originalKey(id), bytes.NewReader(data)); err != nil {
+		log.Printf("store avatar for user %d: %v", id, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.blobs.Put(ctx, a.thumbnailKey(id), &thumb); err != nil {
+		log.Printf("store thumbnail for user %d: %v", id, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	prev, err := store.setAvatar(id, a)
+	if err != nil {
+		// The user was deleted while we were uploading, so nothing will
+		// delete these blobs later.
+		s.deleteBlobs(User{ID: id, Avatar: a})
+		respondStoreError(w, err)
+		return
+	}
+	s.deleteStale(r, id, prev, a)
+
+	respondJSON(w, http.StatusOK, a)
+}
+
+func (s *avatarService) handleDelete(w http.ResponseWriter, r *http.Request) {
+	id, ok := ownAvatarID(w, r)
+	if !ok {
+		return
+	}
+
+	prev, err := storeFor(r).setAvatar(id, nil)
+	if err != nil {
+		respondStoreError(w, err)
+		return
+	}
+	s.deleteStale(r, id, prev, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ownAvatarID returns the user ID from the path if it is the signed-in
+// user's, and answers 403 otherwise.
+func ownAvatarID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return 0, false
+	}
+	if self, _ := sessionUserID(r.Context()); self != id {
+		http.Error(w, "you can only change your own avatar", http.StatusForbidden)
+		return 0, false
+	}
+	return id, true
+}
+
+// deleteBlobs removes the avatar blobs of a deleted user.
+func (s *avatarService) deleteBlobs(u User) {
+	if u.Avatar == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), blobTimeout)
+	defer cancel()
+	for _, key := range []string{u.Avatar.originalKey(u.ID), u.Avatar.thumbnailKey(u.ID)} {
+		if err := s.blobs.Delete(ctx, key); err != nil {
+			log.Printf("delete avatar blob %s of user %d: %v", key, u.ID, err)
+		}
+	}
+}
+
+// deleteStale removes blobs of the previous avatar that the current one didn't overwrite.
+func (s *avatarService) deleteStale(r *http.Request, userID int, prev, cur *Avatar) {
+	if prev == nil {
+		return
+	}
+	if cur == nil || prev.ext != cur.ext {
+		if err := s.blobs.Delete(r.Context(), prev.originalKey(userID)); err != nil {
+			log.Printf("delete old avatar for user %d: %v", userID, err)
+		}
+	}
+	if cur == nil || prev.thumbExt != cur.thumbExt {
+		if err := s.blobs.Delete(r.Context(), prev.thumbnailKey(userID)); err != nil {
+			log.Printf("delete old thumbnail for user %d: %v", userID, err)
+		}
+	}
+}
+
+func (s *avatarService) serve(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	u, err := storeFor(r).getUser(id)
+	if err != nil {
+		respondStoreError(w, err)
+		return
+	}
+	a := u.Avatar
+	if a == nil {
+		http.Error(w, "no avatar", http.StatusNotFound)
+		return
+	}
+
+	key, contentType, etag := a.originalKey(id), a.contentType, `"`+a.version+`"`
+	if thumbnail {
+		key, contentType, etag = a.thumbnailKey(id), a.thumbType, `"`+a.version+`-thumb"`
+	}
+
+	blob, err := s.blobs.Open(r.Context(), key)
+	if errors.Is(err, errBlobNotFound) {
+		http.Error(w, "no avatar", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("open avatar %s: %v", key, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer blob.Close()
+
+	h := w.Header()
+	h.Set("Content-Type", contentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	// ServeContent answers If-None-Match / If-Modified-Since with 304 by itself.
+	h.Set("ETag", etag)
+	if r.URL.Query().Get("v") == a.version {
+		h.Set("Cache-Control", immutableCacheControl)
+	} else {
+		h.Set("Cache-Control", revalidateCacheControl)
+	}
+	http.ServeContent(w, r, "", a.UpdatedAt, blob)
+}
+
+// respondBodyError turns errors from reading the request body into responses.
+func respondBodyError(w http.ResponseWriter, err error) {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	http.Error(w, "invalid multipart body", http.StatusBadRequest)
+}
+
+// encodeThumbnail scales img down to fit in a thumbnailSize square and encodes it.
+func encodeThumbnail(w io.Writer, img image.Image, contentType string) error {
+	thumb := thumbnail(img, thumbnailSize)
+	if contentType == "image/jpeg" {
+		return jpeg.Encode(w, thumb, &jpeg.Options{Quality: 85})
+	}
+	return png.Encode(w, thumb)
+}
+
+// thumbnail shrinks src so that its longer side is at most size pixels, keeping
+// the aspect ratio. Each output pixel is the average of the source pixels it
+// covers (a box filter), which looks much better than nearest-neighbour when
+// shrinking a lot. Images that already fit are returned as is.
+func thumbnail(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	if sw <= size && sh <= size {
+		return src
+	}
+
+	dw, dh := size, size
+	if sw > sh {
+		dh = max(1, sh*size/sw)
+	} else {
+		dw = max(1, sw*size/sh)
+	}
+
+	// Normalize to RGBA once so the loop below can work on raw bytes.
+	rgba := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := dy*sh/dh, (dy+1)*sh/dh
+		for dx := 0; dx < dw; dx++ {
+			x0, x1 := dx*sw/dw, (dx+1)*sw/dw
+
+			var r, g, bl, a, n int
+			for y := y0; y < y1; y++ {
+				row := rgba.Pix[y*rgba.Stride:]
+				for x := x0; x < x1; x++ {
+					p := row[x*4 : x*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					bl += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+
+			o := dst.PixOffset(dx, dy)
+			dst.Pix[o+0] = uint8(r / n)
+			dst.Pix[o+1] = uint8(g / n)
+			dst.Pix[o+2] = uint8(bl / n)
+			dst.Pix[o+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runGenCerts implements the "gen-certs" subcommand: a throwaway CA plus a
+// server and a client certificate signed by it, for trying out TLS and mTLS
+// locally. Never use these outside development.
+func runGenCerts(args []string) error {
+	fs := flag.NewFlagSet("gen-certs", flag.ExitOnError)
+	out := fs.String("out", "certs", "directory to write the PEM files to")
+	hosts := fs.String("hosts", "localhost,127.0.0.1,::1", "comma-separated DNS names and IPs for the server certificate")
+	clientCN := fs.String("client-cn", "dev-client", "common name of the client certificate")
+	clientOrg := fs.String("client-org", "", "organization of the client certificate")
+	validFor := fs.Duration("valid-for", 365*24*time.Hour, "how long the certificates stay valid")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		return err
+	}
+	notAfter := time.Now().Add(*validFor)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	caTmpl := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "rest-playground dev CA"},
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		MaxPathLenZero:        true,
+	}
+	caCert, err := issueCert(caTmpl, caTmpl, &caKey.PublicKey, caKey, *out, "ca")
+	if err != nil {
+		return err
+	}
+
+	serverTmpl := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "rest-playground"},
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range splitList(*hosts) {
+		if ip := net.ParseIP(h); ip != nil {
+			serverTmpl.IPAddresses = append(serverTmpl.IPAddresses, ip)
+		} else {
+			serverTmpl.DNSNames = append(serverTmpl.DNSNames, h)
+		}
+	}
+	if err := issueLeaf(serverTmpl, caCert, caKey, *out, "server"); err != nil {
+		return err
+	}
+
+	clientTmpl := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: *clientCN},
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if *clientOrg != "" {
+		clientTmpl.Subject.Organization = []string{*clientOrg}
+	}
+	if err := issueLeaf(clientTmpl, caCert, caKey, *out, "client"); err != nil {
+		return err
+	}
+
+	fmt.Printf("wrote ca, server and client certificates to %s\n", *out)
+	return nil
+}
+
+// issueLeaf generates a key pair and signs a certificate for it with the CA.
+func issueLeaf(tmpl, ca *x509.Certificate, caKey crypto.Signer, dir, name string) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	if _, err := issueCert(tmpl, ca, &key.PublicKey, caKey, dir, name); err != nil {
+		return err
+	}
+	return writeKey(key, filepath.Join(dir, name+"-key.pem"))
+}
+
+// issueCert signs tmpl with the parent's key and writes <name>.pem.
+// For the self-signed CA, tmpl and parent are the same, and so are the keys,
+// so the CA key is written here too.
+func issueCert(tmpl, parent *x509.Certificate, pub any, signer crypto.Signer, dir, name string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	tmpl.SerialNumber = serial
+	tmpl.NotBefore = time.Now().Add(-time.Minute) // tolerate small clock skew
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, signer)
+	if err != nil {
+		return nil, fmt.Errorf("create %s certificate: %w", name, err)
+	}
+	if err := writePEM(filepath.Join(dir, name+".pem"), "CERTIFICATE", der, 0o644); err != nil {
+		return nil, err
+	}
+	if tmpl == parent {
+		if err := writeKey(signer.(*ecdsa.PrivateKey), filepath.Join(dir, name+"-key.pem")); err != nil {
+			return nil, err
+		}
+	}
+	return x509.ParseCertificate(der)
+}
+
+func writeKey(key *ecdsa.PrivateKey, path string) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	// Private keys are only readable by their owner.
+	return writePEM(path, "PRIVATE KEY", der, 0o600)
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) error {
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), perm)
+}
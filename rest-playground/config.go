@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// serverConfig holds the settings that may change while the server runs.
+// The command-line flags provide the defaults; a -config file overrides any
+// of them and is re-read whenever it changes. Everything else (listen
+// address, TLS, storage paths) still needs a restart.
+type serverConfig struct {
+	CORS        corsSettings      `json:"cors" yaml:"cors"`
+	RateLimit   rateLimitSettings `json:"rate_limit" yaml:"rate_limit"`
+	Tenants     tenantSettings    `json:"tenants" yaml:"tenants"`
+	GraphQL     graphqlSettings   `json:"graphql" yaml:"graphql"`
+	Features    featureSettings   `json:"features" yaml:"features"`
+	Mail        mailSettings      `json:"mail" yaml:"mail"`
+	AdminEmails []string          `json:"admin_emails" yaml:"admin_emails"`
+}
+
+type corsSettings struct {
+	AllowedOrigins   []string `json:"allowed_origins" yaml:"allowed_origins"`
+	AllowedMethods   []string `json:"allowed_methods" yaml:"allowed_methods"`
+	AllowedHeaders   []string `json:"allowed_headers" yaml:"allowed_headers"`
+	AllowCredentials bool     `json:"allow_credentials" yaml:"allow_credentials"`
+	MaxAge           duration `json:"max_age" yaml:"max_age"`
+}
+
+// rateLimitSettings configures rateLimiter. Each client IP gets a bucket of
+// Burst requests that refills at RequestsPerSecond.
+type rateLimitSettings struct {
+	Enabled           bool    `json:"enabled" yaml:"enabled"`
+	RequestsPerSecond float64 `json:"requests_per_second" yaml:"requests_per_second"`
+	Burst             int     `json:"burst" yaml:"burst"`
+}
+
+type tenantSettings struct {
+	MaxUsers int                    `json:"max_users" yaml:"max_users"`
+	MaxTeams int                    `json:"max_teams" yaml:"max_teams"`
+	Quotas   map[string]tenantQuota `json:"quotas" yaml:"quotas"`
+}
+
+// featureSettings switches parts of the API off without a deploy. A disabled
+// feature answers as if the route didn't exist, except registration, which
+// says so explicitly.
+type featureSettings struct {
+	Registration  bool `json:"registration" yaml:"registration"`
+	AdminUI       bool `json:"admin_ui" yaml:"admin_ui"`
+	Jobs          bool `json:"jobs" yaml:"jobs"`
+	AvatarUploads bool `json:"avatar_uploads" yaml:"avatar_uploads"`
+	GraphQL       bool `json:"graphql" yaml:"graphql"`
+}
+
+type mailSettings struct {
+	SMTPAddr     string `json:"smtp_addr" yaml:"smtp_addr"`
+	SMTPFrom     string `json:"smtp_from" yaml:"smtp_from"`
+	SMTPUser     string `json:"smtp_user" yaml:"smtp_user"`
+	SMTPPassword string `json:"smtp_password" yaml:"smtp_password"` // secret
+	Dir          string `json:"dir" yaml:"dir"`
+}
+
+// duration reads and writes durations as strings such as "10m".
+type duration time.Duration
+
+func (d duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *duration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// corsConfig turns the settings into what corsMiddleware needs. The tenant
+// header is always allowed, since every call may carry it.
+func (s corsSettings) corsConfig() corsConfig {
+	headers := slices.Clone(s.AllowedHeaders)
+	if !slices.ContainsFunc(headers, func(h string) bool { return strings.EqualFold(h, tenantHeader) }) {
+		headers = append(headers, tenantHeader)
+	}
+	return corsConfig{
+		AllowedOrigins:   s.AllowedOrigins,
+		AllowedMethods:   s.AllowedMethods,
+		AllowedHeaders:   headers,
+		ExposedHeaders:   []string{"ETag", "Last-Modified", "Location", "Retry-After", "X-Total-Count"},
+		AllowCredentials: s.AllowCredentials,
+		MaxAge:           time.Duration(s.MaxAge),
+	}
+}
+
+func (s tenantSettings) defaultQuota() tenantQuota {
+	return tenantQuota{MaxUsers: s.MaxUsers, MaxTeams: s.MaxTeams}
+}
+
+func (s mailSettings) newMailer() Mailer {
+	if s.SMTPAddr != "" {
+		return newSMTPMailer(s.SMTPAddr, s.SMTPFrom, s.SMTPUser, s.SMTPPassword)
+	}
+	return newOutboxMailer(s.Dir)
+}
+
+// clone returns a deep copy. Decoding into a copy that shares slices or maps
+// with the original would write through to it.
+func (c serverConfig) clone() serverConfig {
+	c.CORS.AllowedOrigins = slices.Clone(c.CORS.AllowedOrigins)
+	c.CORS.AllowedMethods = slices.Clone(c.CORS.AllowedMethods)
+	c.CORS.AllowedHeaders = slices.Clone(c.CORS.AllowedHeaders)
+	c.Tenants.Quotas = maps.Clone(c.Tenants.Quotas)
+	c.AdminEmails = slices.Clone(c.AdminEmails)
+	return c
+}
+
+// redacted returns a copy that is safe to show: secrets are replaced by
+// redactedValue, or left empty when they aren't set.
+func (c serverConfig) redacted() serverConfig {
+	c = c.clone()
+	if c.Mail.SMTPPassword != "" {
+		c.Mail.SMTPPassword = redactedValue
+	}
+	return c
+}
+
+var corsMethodNames = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+// validate reports every problem at once, so a bad edit can be fixed in one go.
+func (c serverConfig) validate() error {
+	var errs []error
+	add := func(format string, args ...any) { errs = append(errs, fmt.Errorf(format, args...)) }
+
+	for _, o := range c.CORS.AllowedOrigins {
+		if o == "*" {
+			continue
+		}
+		u, err := url.Parse(o)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			add("cors.allowed_origins: %q is not an origin like https://app.example.com", o)
+		}
+	}
+	for _, m := range c.CORS.AllowedMethods {
+		if !slices.Contains(corsMethodNames, m) {
+			add("cors.allowed_methods: unknown method %q", m)
+		}
+	}
+	for _, h := range c.CORS.AllowedHeaders {
+		if h == "" || strings.ContainsAny(h, " \t,:") {
+			add("cors.allowed_headers: invalid header name %q", h)
+		}
+	}
+	if c.CORS.MaxAge < 0 || time.Duration(c.CORS.MaxAge) > 24*time.Hour {
+		add("cors.max_age: must be between 0s and 24h")
+	}
+
+	if c.RateLimit.Enabled {
+		if c.RateLimit.RequestsPerSecond <= 0 {
+			add("rate_limit.requests_per_second: must be positive when enabled")
+		}
+		if c.RateLimit.Burst < 1 {
+			add("rate_limit.burst: must be at least 1 when enabled")
+		}
+	}
+
+	if c.Tenants.MaxUsers < 0 || c.Tenants.MaxTeams < 0 {
+		add("tenants: max_users and max_teams must not be negative")
+	}
+	for id, q := range c.Tenants.Quotas {
+		if !tenantIDPattern.MatchString(id) {
+			add("tenants.quotas: %q: %v", id, errInvalidTenantID)
+		}
+		if q.MaxUsers < 0 || q.MaxTeams < 0 {
+			add("tenants.quotas: %q: limits must not be negative", id)
+		}
+	}
+
+	if c.GraphQL.MaxDepth < 1 || c.GraphQL.MaxComplexity < 1 || c.GraphQL.MaxFields < 1 {
+		add("graphql: max_depth, max_complexity and max_fields must be at least 1")
+	}
+
+	if c.Mail.SMTPAddr != "" {
+		if _, _, err := net.SplitHostPort(c.Mail.SMTPAddr); err != nil {
+			add("mail.smtp_addr: %v", err)
+		}
+		if _, err := mail.ParseAddress(c.Mail.SMTPFrom); err != nil {
+			add("mail.smtp_from: %v", err)
+		}
+	}
+
+	for _, entry := range c.AdminEmails {
+		tenantID, email := splitAdminEmail(entry)
+		if !tenantIDPattern.MatchString(tenantID) {
+			add("admin_emails: %q: %v", entry, errInvalidTenantID)
+		}
+		if _, err := mail.ParseAddress(email); err != nil {
+			add("admin_emails: %q: %v", entry, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// decodeConfig overlays the file contents on base. YAML is picked by file
+// extension, anything else is read as JSON. Unknown keys are errors, so a
+// misspelled setting doesn't silently do nothing.
+func decodeConfig(path string, data []byte, base serverConfig) (serverConfig, error) {
+	cfg := base.clone()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return serverConfig{}, err
+		}
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&cfg); err != nil {
+			return serverConfig{}, err
+		}
+	}
+	return cfg, nil
+}
+
+// configManager owns the effective serverConfig. Readers get an immutable
+// snapshot from current; a reload builds and validates a complete new
+// config before anything is applied, so a bad edit never half-applies.
+type configManager struct {
+	path string       // empty when running on flags alone
+	base serverConfig // flag values the file is laid over
+
+	cur atomic.Pointer[serverConfig]
+
+	mu          sync.Mutex // serializes reloads and guards the fields below
+	subscribers []func(*serverConfig)
+	version     string // hash of the file that produced cur
+	loadedAt    time.Time
+	rejected    string // hash of the last file that failed, or its read error, to log it once
+	lastError   string
+	lastErrorAt time.Time
+}
+
+// newConfigManager loads path on top of base. Unlike a reload, a bad file
+// at startup is an error: there is no last good config to keep yet.
+func newConfigManager(path string, base serverConfig) (*configManager, error) {
+	cm := &configManager{path: path, base: base}
+	if path == "" {
+		if err := base.validate(); err != nil {
+			return nil, err
+		}
+		cm.cur.Store(&base)
+		cm.loadedAt = time.Now().UTC()
+		return cm, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := decodeConfig(path, data, base)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	cm.cur.Store(&cfg)
+	cm.version = hashConfig(data)
+	cm.loadedAt = time.Now().UTC()
+	return cm, nil
+}
+
+func hashConfig(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+// current returns the effective config. Callers must not modify it.
+func (cm *configManager) current() *serverConfig {
+	return cm.cur.Load()
+}
+
+// onChange calls fn with the current config right away and again after
+// every successful reload.
+func (cm *configManager) onChange(fn func(*serverConfig)) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.subscribers = append(cm.subscribers, fn)
+	fn(cm.cur.Load())
+}
+
+// reload re-reads the file and applies it if it changed and is valid.
+// Otherwise the current config stays in place.
+func (cm *configManager) reload() error {
+	data, err := os.ReadFile(cm.path)
+	if err != nil {
+		// A missing file fails the same way on every poll; log it once.
+		return cm.reject(unreadablePrefix+err.Error(), err)
+	}
+	version := hashConfig(data)
+
+	cm.mu.Lock()
+	if strings.HasPrefix(cm.rejected, unreadablePrefix) {
+		log.Printf("config: %s is readable again", cm.path)
+		cm.rejected = ""
+	}
+	unchanged := version == cm.version || version == cm.rejected
+	cm.mu.Unlock()
+	if unchanged {
+		return nil
+	}
+
+	cfg, err := decodeConfig(cm.path, data, cm.base)
+	if err == nil {
+		err = cfg.validate()
+	}
+	if err != nil {
+		return cm.reject(version, err)
+	}
+
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.cur.Store(&cfg)
+	for _, fn := range cm.subscribers {
+		fn(&cfg)
+	}
+	cm.version = version
+	cm.rejected = ""
+	cm.loadedAt = time.Now().UTC()
+	log.Printf("config: applied %s (version %s)", cm.path, version)
+	return nil
+}
+
+// unreadablePrefix marks configManager.rejected as a read error rather than
+// the hash of a bad file.
+const unreadablePrefix = "unreadable: "
+
+// reject records a failed reload and logs it, unless it is the same failure
+// as last time: version is the hash of the bad file, or the read error.
+func (cm *configManager) reject(version string, err error) error {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	if version != cm.rejected {
+		log.Printf("config: rejected %s, keeping version %s: %v", cm.path, cm.version, err)
+	}
+	cm.rejected = version
+	cm.lastError = err.Error()
+	cm.lastErrorAt = time.Now().UTC()
+	return err
+}
+
+// watch polls the file until ctx is done. Polling works the same on every
+// platform and inside containers with bind-mounted config files, where
+// change notifications are unreliable.
+func (cm *configManager) watch(ctx context.Context, interval time.Duration) {
+	if cm.path == "" {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_ = cm.reload() // reject already logged it
+		}
+	}
+}
+
+// configStatus is the body of GET /admin/config.
+type configStatus struct {
+	Source      string       `json:"source"` // the file, or "flags"
+	Version     string       `json:"version,omitempty"`
+	LoadedAt    time.Time    `json:"loaded_at"`
+	LastError   string       `json:"last_error,omitempty"`
+	LastErrorAt *time.Time   `json:"last_error_at,omitempty"`
+	Config      serverConfig `json:"config"`
+}
+
+func (cm *configManager) handleShow(w http.ResponseWriter, r *http.Request) {
+	cm.mu.Lock()
+	status := configStatus{
+		Source:    cm.path,
+		Version:   cm.version,
+		LoadedAt:  cm.loadedAt,
+		LastError: cm.lastError,
+		Config:    cm.cur.Load().redacted(),
+	}
+	if cm.lastError != "" {
+		at := cm.lastErrorAt
+		status.LastErrorAt = &at
+	}
+	cm.mu.Unlock()
+
+	if status.Source == "" {
+		status.Source = "flags"
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, status)
+}
+
+// swapHandler forwards to a handler that can be replaced at any time. The
+// CORS middleware is rebuilt on every config change and swapped in here.
+type swapHandler struct {
+	h atomic.Pointer[http.Handler]
+}
+
+func (s *swapHandler) set(h http.Handler) {
+	s.h.Store(&h)
+}
+
+func (s *swapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.h.Load()).ServeHTTP(w, r)
+}
+
+// featureMiddleware turns away requests for features switched off in the
+// current config.
+func featureMiddleware(cm *configManager, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f := cm.current().Features
+		path := r.URL.Path
+		switch {
+		case !f.Registration && r.Method == http.MethodPost && path == "/auth/register":
+			http.Error(w, "registration is disabled", http.StatusForbidden)
+			return
+		// /admin/config stays reachable, or turning the UI off would also
+		// hide the switch that did it.
+		case !f.AdminUI && (path == "/admin" || strings.HasPrefix(path, "/admin/")) && path != "/admin/config":
+			http.NotFound(w, r)
+			return
+		case !f.Jobs && (path == "/jobs" || strings.HasPrefix(path, "/jobs/")):
+			http.NotFound(w, r)
+			return
+		case !f.GraphQL && path == "/graphql":
+			http.NotFound(w, r)
+			return
+		case !f.AvatarUploads && r.Method == http.MethodPut && strings.HasPrefix(path, "/users/") && strings.HasSuffix(path, "/avatar"):
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
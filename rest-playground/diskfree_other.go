@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd)
+
+package main
+
+import "errors"
+
+var errDiskFreeUnsupported = errors.New("disk free space not supported on this platform")
+
+// diskFree is not implemented here; diskSpaceCheck treats that as healthy.
+func diskFree(_ string) (uint64, error) {
+	return 0, errDiskFreeUnsupported
+}
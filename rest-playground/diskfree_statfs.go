@@ -0,0 +1,21 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+var errDiskFreeUnsupported = errors.New("disk free space not supported on this platform")
+
+// diskFree returns the bytes available to unprivileged users on the
+// filesystem that holds path.
+func diskFree(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	// Field types differ between platforms, hence the conversions.
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
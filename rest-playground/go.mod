@@ -1,3 +1,9 @@
 module github.com/cristianmanoliu/learning-golang/rest-playground
 
-go 1.25
+go 1.25.0
+
+require (
+	github.com/graphql-go/graphql v0.8.1
+	golang.org/x/crypto v0.54.0
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,636 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/location"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+const (
+	// defaultPageSize and maxPageSize bound users(first:).
+	defaultPageSize = 20
+	maxPageSize     = 100
+	// listCostEstimate is what the complexity check assumes a list field
+	// without a page size (a user's teams, a team's members) returns.
+	listCostEstimate = 10
+	// maxGraphQLBody caps a POST body; queries are small, and parsing and
+	// validating one costs far more than reading it.
+	maxGraphQLBody = 1 << 20 // 1 MiB
+)
+
+// graphqlSettings limits what a single GraphQL request may ask for.
+type graphqlSettings struct {
+	MaxDepth      int `json:"max_depth" yaml:"max_depth"`
+	MaxComplexity int `json:"max_complexity" yaml:"max_complexity"`
+	// MaxFields caps the fields written in the document, aliases and unused
+	// fragments included. It is checked before validation, whose cost grows
+	// faster than the number of fields.
+	MaxFields int `json:"max_fields" yaml:"max_fields"`
+}
+
+// graphqlError is an error with a machine-readable code, which ends up in
+// the "extensions" of the GraphQL error.
+type graphqlError struct {
+	code    string
+	message string
+}
+
+func (e graphqlError) Error() string { return e.message }
+
+func (e graphqlError) Extensions() map[string]any {
+	return map[string]any{"code": e.code}
+}
+
+// gqlStoreError turns store errors into coded GraphQL errors.
+func gqlStoreError(err error) error {
+	switch {
+	case errors.Is(err, errUserNotFound):
+		return graphqlError{"NOT_FOUND", "user not found"}
+	case errors.Is(err, errQuotaExceeded):
+		return graphqlError{"QUOTA_EXCEEDED", err.Error()}
+	default:
+		return err
+	}
+}
+
+func gqlStore(ctx context.Context) *userStore {
+	return tenantFrom(ctx).store
+}
+
+// gqlID parses a GraphQL ID argument into a store ID.
+func gqlID(v any) (int, error) {
+	s, _ := v.(string)
+	id, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, graphqlError{"BAD_USER_INPUT", fmt.Sprintf("invalid id %q", s)}
+	}
+	return id, nil
+}
+
+// Cursors are opaque to clients. They hold the ID of the last user on the
+// page, which stays valid when users before it are deleted, unlike an offset.
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("user:" + strconv.Itoa(id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		if s, ok := strings.CutPrefix(string(raw), "user:"); ok {
+			if id, err := strconv.Atoi(s); err == nil {
+				return id, nil
+			}
+		}
+	}
+	return 0, graphqlError{"BAD_USER_INPUT", "invalid cursor"}
+}
+
+// userConnection is the result of users(): a page in the Relay connection shape.
+type userConnection struct {
+	Edges      []userEdge
+	TotalCount int
+	HasNext    bool
+	EndCursor  string
+}
+
+type userEdge struct {
+	Cursor string
+	Node   User
+}
+
+// newGraphQLSchema builds the schema. Every resolver works on the store of
+// the request tenant, like the REST handlers.
+func newGraphQLSchema() (graphql.Schema, error) {
+	avatarType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Avatar",
+		Fields: graphql.Fields{
+			"url": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return p.Source.(*Avatar).URL, nil
+			}},
+			"thumbnailUrl": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return p.Source.(*Avatar).ThumbnailURL, nil
+			}},
+			"updatedAt": &graphql.Field{Type: graphql.NewNonNull(graphql.DateTime), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return p.Source.(*Avatar).UpdatedAt, nil
+			}},
+		},
+	})
+
+	userType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "User",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{Type: graphql.NewNonNull(graphql.ID), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return strconv.Itoa(p.Source.(User).ID), nil
+			}},
+			"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return p.Source.(User).Name, nil
+			}},
+			"email": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (any, error) {
+				if u := p.Source.(User); u.Email != "" {
+					return u.Email, nil
+				}
+				return nil, nil
+			}},
+			"emailVerified": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return p.Source.(User).EmailVerified, nil
+			}},
+			"avatar": &graphql.Field{Type: avatarType, Resolve: func(p graphql.ResolveParams) (any, error) {
+				if a := p.Source.(User).Avatar; a != nil {
+					return a, nil
+				}
+				return nil, nil
+			}},
+		},
+	})
+
+	teamType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Team",
+		Fields: graphql.Fields{
+			"id": &graphql.Field{Type: graphql.NewNonNull(graphql.ID), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return strconv.Itoa(p.Source.(Team).ID), nil
+			}},
+			"name": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return p.Source.(Team).Name, nil
+			}},
+			"members": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(userType))), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return gqlStore(p.Context).teamMembers(p.Source.(Team).ID)
+			}},
+		},
+	})
+
+	// User and Team refer to each other, so this field is added afterwards.
+	userType.AddFieldConfig("teams", &graphql.Field{
+		Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(teamType))),
+		Resolve: func(p graphql.ResolveParams) (any, error) {
+			teams, err := gqlStore(p.Context).userTeams(p.Source.(User).ID)
+			return teams, gqlStoreError(err)
+		},
+	})
+
+	edgeType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "UserEdge",
+		Fields: graphql.Fields{
+			"cursor": &graphql.Field{Type: graphql.NewNonNull(graphql.String), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return p.Source.(userEdge).Cursor, nil
+			}},
+			"node": &graphql.Field{Type: graphql.NewNonNull(userType), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return p.Source.(userEdge).Node, nil
+			}},
+		},
+	})
+
+	pageInfoType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "PageInfo",
+		Fields: graphql.Fields{
+			"hasNextPage": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return p.Source.(userConnection).HasNext, nil
+			}},
+			"endCursor": &graphql.Field{Type: graphql.String, Resolve: func(p graphql.ResolveParams) (any, error) {
+				if c := p.Source.(userConnection).EndCursor; c != "" {
+					return c, nil
+				}
+				return nil, nil
+			}},
+		},
+	})
+
+	connectionType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "UserConnection",
+		Fields: graphql.Fields{
+			"edges": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(edgeType))), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return p.Source.(userConnection).Edges, nil
+			}},
+			"pageInfo": &graphql.Field{Type: graphql.NewNonNull(pageInfoType), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return p.Source, nil
+			}},
+			"totalCount": &graphql.Field{Type: graphql.NewNonNull(graphql.Int), Resolve: func(p graphql.ResolveParams) (any, error) {
+				return p.Source.(userConnection).TotalCount, nil
+			}},
+		},
+	})
+
+	filterType := graphql.NewInputObject(graphql.InputObjectConfig{
+		Name: "UserFilter",
+		Fields: graphql.InputObjectConfigFieldMap{
+			"query": &graphql.InputObjectFieldConfig{
+				Type:        graphql.String,
+				Description: "Case-insensitive substring of the name or email.",
+			},
+		},
+	})
+
+	queryType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"user": &graphql.Field{
+				Type: userType,
+				Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					id, err := gqlID(p.Args["id"])
+					if err != nil {
+						return nil, err
+					}
+					u, err := gqlStore(p.Context).getUser(id)
+					if errors.Is(err, errUserNotFound) {
+						return nil, nil // a missing user is null, not an error
+					}
+					return u, err
+				},
+			},
+			"users": &graphql.Field{
+				Type: graphql.NewNonNull(connectionType),
+				Args: graphql.FieldConfigArgument{
+					"first":  &graphql.ArgumentConfig{Type: graphql.Int, DefaultValue: defaultPageSize},
+					"after":  &graphql.ArgumentConfig{Type: graphql.String},
+					"filter": &graphql.ArgumentConfig{Type: filterType},
+				},
+				Resolve: resolveUsers,
+			},
+		},
+	})
+
+	mutationType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"createUser": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Args: graphql.FieldConfigArgument{"name": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					name := p.Args["name"].(string)
+					if name == "" {
+						return nil, graphqlError{"BAD_USER_INPUT", "name is required"}
+					}
+					u, err := gqlStore(p.Context).addUser(name)
+					if err != nil {
+						return nil, gqlStoreError(err)
+					}
+					return u, nil
+				},
+			},
+			"updateUser": &graphql.Field{
+				Type: graphql.NewNonNull(userType),
+				Args: graphql.FieldConfigArgument{
+					"id":   &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"name": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					id, err := gqlID(p.Args["id"])
+					if err != nil {
+						return nil, err
+					}
+					name := p.Args["name"].(string)
+					if name == "" {
+						return nil, graphqlError{"BAD_USER_INPUT", "name is required"}
+					}
+					u, err := gqlStore(p.Context).updateUser(id, name)
+					if err != nil {
+						return nil, gqlStoreError(err)
+					}
+					return u, nil
+				},
+			},
+			"deleteUser": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.Boolean),
+				Args: graphql.FieldConfigArgument{"id": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)}},
+				Resolve: func(p graphql.ResolveParams) (any, error) {
+					id, err := gqlID(p.Args["id"])
+					if err != nil {
+						return nil, err
+					}
+					if err := gqlStore(p.Context).deleteUser(id); err != nil {
+						return nil, gqlStoreError(err)
+					}
+					return true, nil
+				},
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{Query: queryType, Mutation: mutationType})
+}
+
+// resolveUsers returns the page of users after the cursor, in creation
+// order. Users are kept sorted by ID, so "after" is the first ID above it.
+func resolveUsers(p graphql.ResolveParams) (any, error) {
+	first, _ := p.Args["first"].(int)
+	if first < 0 || first > maxPageSize {
+		return nil, graphqlError{"BAD_USER_INPUT", fmt.Sprintf("first must be between 0 and %d", maxPageSize)}
+	}
+	afterID := 0
+	if after, ok := p.Args["after"].(string); ok && after != "" {
+		id, err := decodeCursor(after)
+		if err != nil {
+			return nil, err
+		}
+		afterID = id
+	}
+	query := ""
+	if filter, ok := p.Args["filter"].(map[string]any); ok {
+		query, _ = filter["query"].(string)
+	}
+
+	matches, total := gqlStore(p.Context).findUsers(query, 0, 0)
+	start := 0
+	for start < len(matches) && matches[start].ID <= afterID {
+		start++
+	}
+	end := min(start+first, len(matches))
+
+	conn := userConnection{TotalCount: total, HasNext: end < len(matches), Edges: make([]userEdge, 0, end-start)}
+	for _, u := range matches[start:end] {
+		conn.Edges = append(conn.Edges, userEdge{Cursor: encodeCursor(u.ID), Node: u})
+	}
+	if len(conn.Edges) > 0 {
+		conn.EndCursor = conn.Edges[len(conn.Edges)-1].Cursor
+	}
+	return conn, nil
+}
+
+// queryCost walks a document and measures the operation that will run.
+type queryCost struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]any
+	// measured caches the cost of each fragment, so a fragment spread many
+	// times, or spreading others that are, is walked once rather than once
+	// per path to it.
+	measured map[string]fragmentCost
+}
+
+type fragmentCost struct{ depth, complexity int }
+
+// measure returns the depth and complexity of a selection set. Each field
+// costs 1 plus its children; the children of list fields count once per
+// element they may return. Introspection fields count like any other, but
+// their lists only once, as the schema they describe is small and fixed.
+//
+// It runs before validation, so unknown and cyclic fragments are skipped
+// here and reported by validation afterwards.
+func (qc *queryCost) measure(set *ast.SelectionSet, seen map[string]bool) (depth, complexity int) {
+	if set == nil {
+		return 0, 0
+	}
+	for _, sel := range set.Selections {
+		var d, c int
+		switch sel := sel.(type) {
+		case *ast.Field:
+			d, c = qc.measure(sel.SelectionSet, seen)
+			d++
+			c = 1 + qc.listSize(sel)*c
+		case *ast.InlineFragment:
+			d, c = qc.measure(sel.SelectionSet, seen)
+		case *ast.FragmentSpread:
+			name := sel.Name.Value
+			frag, ok := qc.fragments[name]
+			if !ok || seen[name] {
+				continue // unknown or cyclic
+			}
+			if fc, ok := qc.measured[name]; ok {
+				d, c = fc.depth, fc.complexity
+				break
+			}
+			seen[name] = true
+			d, c = qc.measure(frag.SelectionSet, seen)
+			delete(seen, name)
+			qc.measured[name] = fragmentCost{d, c}
+		}
+		depth = max(depth, d)
+		complexity += c
+	}
+	return depth, complexity
+}
+
+// listSize is how many elements a field may return.
+func (qc *queryCost) listSize(f *ast.Field) int {
+	switch f.Name.Value {
+	case "users":
+		for _, arg := range f.Arguments {
+			if arg.Name.Value != "first" {
+				continue
+			}
+			switch v := arg.Value.(type) {
+			case *ast.IntValue:
+				if n, err := strconv.Atoi(v.Value); err == nil {
+					return min(max(n, 0), maxPageSize)
+				}
+			case *ast.Variable:
+				if n, ok := qc.variables[v.Name.Value].(float64); ok {
+					return min(max(int(n), 0), maxPageSize)
+				}
+			}
+		}
+		return defaultPageSize
+	case "teams", "members":
+		return listCostEstimate
+	}
+	return 1
+}
+
+// countFields counts the fields written in a selection set, the fields of
+// fragments it spreads not included.
+func countFields(set *ast.SelectionSet) int {
+	if set == nil {
+		return 0
+	}
+	n := 0
+	for _, sel := range set.Selections {
+		switch sel := sel.(type) {
+		case *ast.Field:
+			n += 1 + countFields(sel.SelectionSet)
+		case *ast.InlineFragment:
+			n += countFields(sel.SelectionSet)
+		}
+	}
+	return n
+}
+
+// checkSize rejects documents with more than limits.MaxFields fields, before
+// any time goes into validating them.
+func checkSize(doc *ast.Document, limits graphqlSettings) []gqlerrors.FormattedError {
+	n := 0
+	for _, def := range doc.Definitions {
+		switch def := def.(type) {
+		case *ast.FragmentDefinition:
+			n += countFields(def.SelectionSet)
+		case *ast.OperationDefinition:
+			n += countFields(def.SelectionSet)
+		}
+	}
+	if limits.MaxFields > 0 && n > limits.MaxFields {
+		err := graphqlError{"QUERY_TOO_LARGE", fmt.Sprintf("query has %d fields, more than the limit of %d", n, limits.MaxFields)}
+		return []gqlerrors.FormattedError{gqlerrors.FormatError(gqlerrors.NewLocatedError(err, nil))}
+	}
+	return nil
+}
+
+// checkLimits rejects operations that are too deep or too expensive before
+// they are validated or any resolver runs.
+func checkLimits(doc *ast.Document, operationName string, variables map[string]any, limits graphqlSettings) []gqlerrors.FormattedError {
+	qc := &queryCost{
+		fragments: make(map[string]*ast.FragmentDefinition),
+		variables: variables,
+		measured:  make(map[string]fragmentCost),
+	}
+	var ops []*ast.OperationDefinition
+	for _, def := range doc.Definitions {
+		switch def := def.(type) {
+		case *ast.FragmentDefinition:
+			qc.fragments[def.Name.Value] = def
+		case *ast.OperationDefinition:
+			if operationName == "" || (def.Name != nil && def.Name.Value == operationName) {
+				ops = append(ops, def)
+			}
+		}
+	}
+
+	var errs []gqlerrors.FormattedError
+	for _, op := range ops {
+		depth, complexity := qc.measure(op.SelectionSet, make(map[string]bool))
+		if limits.MaxDepth > 0 && depth > limits.MaxDepth {
+			errs = append(errs, limitError(op, "QUERY_TOO_DEEP",
+				fmt.Sprintf("query depth %d exceeds the limit of %d", depth, limits.MaxDepth)))
+		}
+		if limits.MaxComplexity > 0 && complexity > limits.MaxComplexity {
+			errs = append(errs, limitError(op, "QUERY_TOO_COMPLEX",
+				fmt.Sprintf("query complexity %d exceeds the limit of %d", complexity, limits.MaxComplexity)))
+		}
+	}
+	return errs
+}
+
+func limitError(op *ast.OperationDefinition, code, message string) gqlerrors.FormattedError {
+	// FormatError picks the code up from graphqlError.Extensions.
+	return gqlerrors.FormatError(gqlerrors.NewLocatedError(graphqlError{code, message}, []ast.Node{op}))
+}
+
+// graphqlRequest is the standard GraphQL-over-HTTP request body. GET
+// requests carry the same fields as query parameters.
+type graphqlRequest struct {
+	Query         string         `json:"query"`
+	OperationName string         `json:"operationName"`
+	Variables     map[string]any `json:"variables"`
+}
+
+type graphqlService struct {
+	schema  graphql.Schema
+	configs *configManager
+}
+
+func registerGraphQLRoutes(mux *http.ServeMux, gql *graphqlService) {
+	mux.HandleFunc("GET /graphql", gql.handle)
+	mux.HandleFunc("POST /graphql", gql.handle)
+}
+
+// handle runs one GraphQL request. Like most GraphQL servers it answers
+// 200 whenever it got as far as parsing the request, with problems listed
+// in "errors"; only a request that isn't GraphQL at all gets a 400.
+func (gql *graphqlService) handle(w http.ResponseWriter, r *http.Request) {
+	var req graphqlRequest
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		req.Query = q.Get("query")
+		req.OperationName = q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				respondGraphQLError(w, http.StatusBadRequest, "BAD_REQUEST", "variables must be a JSON object")
+				return
+			}
+		}
+	} else if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxGraphQLBody)).Decode(&req); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			respondGraphQLError(w, http.StatusRequestEntityTooLarge, "BAD_REQUEST", "request body too large")
+			return
+		}
+		respondGraphQLError(w, http.StatusBadRequest, "BAD_REQUEST", "invalid JSON body")
+		return
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		respondGraphQLError(w, http.StatusBadRequest, "BAD_REQUEST", "query is required")
+		return
+	}
+
+	src := source.NewSource(&source.Source{Body: []byte(req.Query), Name: "GraphQL request"})
+	doc, err := parser.Parse(parser.ParseParams{Source: src})
+	if err != nil {
+		respondJSON(w, http.StatusOK, graphqlErrors{withCode(gqlerrors.FormatErrors(err), "GRAPHQL_PARSE_FAILED")})
+		return
+	}
+	// The limits come first: validation takes time that grows with the
+	// size of the document, and measuring it doesn't.
+	limits := gql.configs.current().GraphQL
+	if errs := checkSize(doc, limits); len(errs) > 0 {
+		respondJSON(w, http.StatusOK, graphqlErrors{errs})
+		return
+	}
+	if errs := checkLimits(doc, req.OperationName, req.Variables, limits); len(errs) > 0 {
+		respondJSON(w, http.StatusOK, graphqlErrors{errs})
+		return
+	}
+	if v := graphql.ValidateDocument(&gql.schema, doc, nil); !v.IsValid {
+		respondJSON(w, http.StatusOK, graphqlErrors{withCode(v.Errors, "GRAPHQL_VALIDATION_FAILED")})
+		return
+	}
+
+	// GET must be safe, so it can't run mutations.
+	if r.Method == http.MethodGet && hasMutation(doc, req.OperationName) {
+		w.Header().Set("Allow", "POST")
+		respondGraphQLError(w, http.StatusMethodNotAllowed, "BAD_REQUEST", "mutations need POST")
+		return
+	}
+
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:        gql.schema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       r.Context(),
+	})
+	respondJSON(w, http.StatusOK, result)
+}
+
+func hasMutation(doc *ast.Document, operationName string) bool {
+	for _, def := range doc.Definitions {
+		op, ok := def.(*ast.OperationDefinition)
+		if ok && op.Operation == ast.OperationTypeMutation &&
+			(operationName == "" || (op.Name != nil && op.Name.Value == operationName)) {
+			return true
+		}
+	}
+	return false
+}
+
+// withCode adds an extensions code to errors that don't have one yet.
+func withCode(errs []gqlerrors.FormattedError, code string) []gqlerrors.FormattedError {
+	for i := range errs {
+		if errs[i].Extensions == nil {
+			errs[i].Extensions = map[string]any{"code": code}
+		}
+	}
+	return errs
+}
+
+// graphqlErrors is the response when a request fails before execution. The
+// spec says "data" must then be left out, not set to null.
+type graphqlErrors struct {
+	Errors []gqlerrors.FormattedError `json:"errors"`
+}
+
+func respondGraphQLError(w http.ResponseWriter, status int, code, message string) {
+	respondJSON(w, status, graphqlErrors{[]gqlerrors.FormattedError{{
+		Message:    message,
+		Locations:  []location.SourceLocation{},
+		Extensions: map[string]any{"code": code},
+	}}})
+}
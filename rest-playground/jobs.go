@@ -0,0 +1,720 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// JobStatus is where a job is in its life cycle.
+type JobStatus string
+
+const (
+	jobQueued    JobStatus = "queued"
+	jobRunning   JobStatus = "running"
+	jobSucceeded JobStatus = "succeeded"
+	jobFailed    JobStatus = "failed"
+	jobCancelled JobStatus = "cancelled"
+
+	jobImportUsers = "import_users"
+	jobExportUsers = "export_users"
+
+	maxJobBody = 32 << 20 // 32 MiB
+	// Persisting after every single item would rewrite the jobs file thousands
+	// of times for a big import, so progress is only saved every so often.
+	jobProgressSaveEvery = 100
+)
+
+var (
+	errJobNotFound  = errors.New("job not found")
+	errJobFinished  = errors.New("job already finished")
+	errJobQueueFull = errors.New("job queue is full")
+	errJobsClosed   = errors.New("server is shutting down")
+)
+
+func (s JobStatus) finished() bool {
+	return s == jobSucceeded || s == jobFailed || s == jobCancelled
+}
+
+// Job is the public view of a background job.
+type Job struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Status     JobStatus  `json:"status"`
+	Done       int        `json:"done"`
+	Total      int        `json:"total"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ResultURL  string     `json:"result_url,omitempty"`
+}
+
+// jobRecord is what gets persisted: the job plus the input it was submitted with.
+type jobRecord struct {
+	Job
+	// Tenant owns the job; other tenants can't see it. Records written before
+	// tenants existed have none and belong to the default tenant.
+	Tenant            string          `json:"tenant,omitempty"`
+	Params            json.RawMessage `json:"params,omitempty"`
+	ResultKey         string          `json:"result_key,omitempty"`
+	ResultContentType string          `json:"result_content_type,omitempty"`
+}
+
+// jobRepository persists job records between restarts.
+type jobRepository interface {
+	load() ([]*jobRecord, error)
+	save(records []*jobRecord) error
+}
+
+// memoryJobRepository doesn't persist anything; jobs die with the process.
+type memoryJobRepository struct{}
+
+func (memoryJobRepository) load() ([]*jobRecord, error) { return nil, nil }
+func (memoryJobRepository) save(_ []*jobRecord) error   { return nil }
+
+// fileJobRepository keeps all job records in one JSON file.
+type fileJobRepository struct {
+	path string
+}
+
+func (r fileJobRepository) load() ([]*jobRecord, error) {
+	data, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []*jobRecord
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", r.path, err)
+	}
+	return records, nil
+}
+
+// save writes a temp file and renames it over the old one, so a crash
+// mid-write never leaves a truncated jobs file behind.
+func (r fileJobRepository) save(records []*jobRecord) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return err
+	}
+	tmp := r.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.path)
+}
+
+// jobManager runs jobs on a fixed number of workers fed from a bounded queue.
+// It is shared by all tenants; each job works on its own tenant's store.
+type jobManager struct {
+	tenants *tenantRegistry
+	blobs   BlobStore
+	repo    jobRepository
+	queue   chan string
+
+	mu      sync.Mutex
+	jobs    map[string]*jobRecord
+	cancels map[string]context.CancelFunc
+	// version counts changes to jobs; savedVersion is the newest one on
+	// disk. saveMu orders the writes, so an older snapshot that lost the
+	// race to the file never overwrites a newer one.
+	version      uint64
+	saveMu       sync.Mutex
+	savedVersion uint64
+	// closed stops new jobs from being accepted or started; interrupted is
+	// set when shutdown had to cancel jobs that were still running.
+	closed      bool
+	interrupted bool
+	running     sync.WaitGroup
+}
+
+// newJobManager loads persisted jobs and starts the workers. Jobs that were
+// still queued are queued again, and so are exports that were running when the
+// process died. Interrupted imports are marked failed instead: they can't tell
+// which of their users were already created.
+func newJobManager(tenants *tenantRegistry, blobs BlobStore, repo jobRepository, workers, queueSize int) (*jobManager, error) {
+	m := &jobManager{
+		tenants: tenants,
+		blobs:   blobs,
+		repo:    repo,
+		queue:   make(chan string, queueSize),
+		jobs:    make(map[string]*jobRecord),
+		cancels: make(map[string]context.CancelFunc),
+	}
+
+	records, err := repo.load()
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(records, func(a, b *jobRecord) int { return a.CreatedAt.Compare(b.CreatedAt) })
+
+	var requeue []string
+	for _, rec := range records {
+		if rec.Tenant == "" {
+			rec.Tenant = defaultTenantID
+		}
+		m.jobs[rec.ID] = rec
+		switch rec.Status {
+		case jobRunning:
+			if rec.Type == jobExportUsers {
+				rec.Status = jobQueued
+				rec.StartedAt = nil
+				rec.Done, rec.Total = 0, 0
+				requeue = append(requeue, rec.ID)
+				continue
+			}
+			now := time.Now().UTC()
+			rec.Status = jobFailed
+			rec.Error = "interrupted by server restart"
+			rec.FinishedAt = &now
+		case jobQueued:
+			requeue = append(requeue, rec.ID)
+		}
+	}
+	if len(requeue) > cap(m.queue) {
+		m.queue = make(chan string, len(requeue))
+	}
+	for _, id := range requeue {
+		m.queue <- id
+	}
+	m.persist()
+
+	for range workers {
+		go m.worker()
+	}
+	return m, nil
+}
+
+// persist saves every job. It holds m.mu only while copying the records,
+// so GET /jobs and the workers don't wait for the file to be written.
+// Callers must not hold m.mu.
+func (m *jobManager) persist() {
+	m.mu.Lock()
+	m.version++
+	version := m.version
+	records := make([]*jobRecord, 0, len(m.jobs))
+	for _, rec := range m.jobs {
+		cp := *rec
+		records = append(records, &cp)
+	}
+	m.mu.Unlock()
+
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+	if version <= m.savedVersion {
+		return // a newer snapshot is on disk already
+	}
+	if err := m.repo.save(records); err != nil {
+		log.Printf("persist jobs: %v", err)
+		return
+	}
+	m.savedVersion = version
+}
+
+// submit queues a job for a tenant.
+func (m *jobManager) submit(tenantID, jobType string, params json.RawMessage) (Job, error) {
+	id, err := newJobID()
+	if err != nil {
+		return Job{}, err
+	}
+	rec := &jobRecord{
+		Job: Job{
+			ID:        id,
+			Type:      jobType,
+			Status:    jobQueued,
+			CreatedAt: time.Now().UTC(),
+		},
+		Tenant: tenantID,
+		Params: params,
+	}
+
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return Job{}, errJobsClosed
+	}
+	select {
+	case m.queue <- id:
+	default:
+		m.mu.Unlock()
+		return Job{}, errJobQueueFull
+	}
+	m.jobs[id] = rec
+	job := rec.Job
+	m.mu.Unlock()
+
+	m.persist()
+	return job, nil
+}
+
+// get returns a copy of a tenant's job. Another tenant's job looks exactly
+// like a missing one, so job IDs don't leak across tenants.
+func (m *jobManager) get(tenantID, id string) (*jobRecord, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.jobs[id]
+	if !ok || rec.Tenant != tenantID {
+		return nil, errJobNotFound
+	}
+	cp := *rec
+	return &cp, nil
+}
+
+// list returns a tenant's jobs, newest first.
+func (m *jobManager) list(tenantID string) []Job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]Job, 0)
+	for _, rec := range m.jobs {
+		if rec.Tenant == tenantID {
+			result = append(result, rec.Job)
+		}
+	}
+	slices.SortFunc(result, func(a, b Job) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return result
+}
+
+// cancel stops a tenant's queued or running job.
+func (m *jobManager) cancel(tenantID, id string) (Job, error) {
+	m.mu.Lock()
+	rec, ok := m.jobs[id]
+	if !ok || rec.Tenant != tenantID {
+		m.mu.Unlock()
+		return Job{}, errJobNotFound
+	}
+	if rec.Status.finished() {
+		m.mu.Unlock()
+		return rec.Job, errJobFinished
+	}
+
+	if cancel, running := m.cancels[id]; running {
+		// The worker notices and records the cancellation itself.
+		cancel()
+		m.mu.Unlock()
+		return rec.Job, nil
+	}
+	// Still queued: the worker skips it when it comes up.
+	now := time.Now().UTC()
+	rec.Status = jobCancelled
+	rec.FinishedAt = &now
+	job := rec.Job
+	m.mu.Unlock()
+
+	m.persist()
+	return job, nil
+}
+
+func (m *jobManager) worker() {
+	for id := range m.queue {
+		m.run(id)
+	}
+}
+
+func (m *jobManager) run(id string) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	m.mu.Lock()
+	rec, ok := m.jobs[id]
+	if !ok || rec.Status != jobQueued || m.closed {
+		// After shutdown the job stays queued in the repository and runs
+		// again with the next process.
+		m.mu.Unlock()
+		return
+	}
+	m.running.Add(1)
+	defer m.running.Done()
+	now := time.Now().UTC()
+	rec.Status = jobRunning
+	rec.StartedAt = &now
+	m.cancels[id] = cancel
+	params := rec.Params
+	jobType := rec.Type
+	tenantID := rec.Tenant
+	// An import's users can be megabytes, and an interrupted import is
+	// never run again, so there is no reason to keep rewriting them into
+	// the jobs file. Exports keep their params to be requeued after a
+	// restart.
+	if jobType == jobImportUsers {
+		rec.Params = nil
+	}
+	m.mu.Unlock()
+	m.persist()
+
+	t, err := m.tenants.get(tenantID)
+	if err == nil {
+		switch jobType {
+		case jobImportUsers:
+			err = m.runImport(ctx, t, id, params)
+		case jobExportUsers:
+			err = m.runExport(ctx, t, id, params)
+		default:
+			err = fmt.Errorf("unknown job type %q", jobType)
+		}
+	}
+
+	m.mu.Lock()
+	delete(m.cancels, id)
+	finished := time.Now().UTC()
+	rec.FinishedAt = &finished
+	switch {
+	case errors.Is(err, context.Canceled) && m.interrupted:
+		// Cancelled by shutdown, not by the user: handled like a crash on restart.
+		if rec.Type == jobExportUsers {
+			rec.Status = jobQueued
+			rec.StartedAt, rec.FinishedAt = nil, nil
+			rec.Done, rec.Total = 0, 0
+		} else {
+			rec.Status = jobFailed
+			rec.Error = "interrupted by server shutdown"
+		}
+	case errors.Is(err, context.Canceled):
+		rec.Status = jobCancelled
+	case err != nil:
+		rec.Status = jobFailed
+		rec.Error = err.Error()
+	default:
+		rec.Status = jobSucceeded
+		rec.ResultURL = "/jobs/" + id + "/result"
+	}
+	m.mu.Unlock()
+	m.persist()
+}
+
+// shutdown stops accepting and starting jobs, then waits for the running ones
+// to finish. If ctx expires first, they are cancelled: exports go back to the
+// queue for the next process, imports are marked failed.
+func (m *jobManager) shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	m.closed = true
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		m.mu.Lock()
+		m.interrupted = true
+		for _, cancel := range m.cancels {
+			cancel()
+		}
+		m.mu.Unlock()
+		<-done
+		return ctx.Err()
+	}
+}
+
+// pruneFinished forgets jobs that finished before cutoff and deletes their
+// results, so the jobs file and the blob store don't grow forever.
+func (m *jobManager) pruneFinished(ctx context.Context, cutoff time.Time) (int, error) {
+	m.mu.Lock()
+	var keys []string
+	pruned := 0
+	for id, rec := range m.jobs {
+		if rec.Status.finished() && rec.FinishedAt != nil && rec.FinishedAt.Before(cutoff) {
+			delete(m.jobs, id)
+			pruned++
+			if rec.ResultKey != "" {
+				keys = append(keys, rec.ResultKey)
+			}
+		}
+	}
+	m.mu.Unlock()
+	if pruned > 0 {
+		m.persist()
+	}
+
+	// The records are gone already, so a result left behind here is only
+	// wasted space, never a dangling link.
+	var errs []error
+	for _, key := range keys {
+		if err := m.blobs.Delete(ctx, key); err != nil {
+			errs = append(errs, fmt.Errorf("delete %s: %w", key, err))
+		}
+	}
+	return pruned, errors.Join(errs...)
+}
+
+// progress updates the done/total counters of a running job.
+func (m *jobManager) progress(id string, done, total int) {
+	m.mu.Lock()
+	rec := m.jobs[id]
+	rec.Done, rec.Total = done, total
+	m.mu.Unlock()
+
+	if done%jobProgressSaveEvery == 0 || done == total {
+		m.persist()
+	}
+}
+
+// storeResult uploads the job output and remembers where it lives.
+func (m *jobManager) storeResult(ctx context.Context, t *tenant, id, ext, contentType string, data []byte) error {
+	key := "tenants/" + t.id + "/jobs/" + id + "/result." + ext
+	if err := m.blobs.Put(ctx, key, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("store result: %w", err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.jobs[id].ResultKey = key
+	m.jobs[id].ResultContentType = contentType
+	return nil
+}
+
+// validateJobParams checks params up front, so a bad item can't fail an
+// import halfway, after the items before it were created.
+func validateJobParams(jobType string, raw json.RawMessage) error {
+	switch jobType {
+	case jobImportUsers:
+		if len(raw) == 0 {
+			return errors.New("params are required")
+		}
+		var params importUsersParams
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return fmt.Errorf("invalid params: %w", err)
+		}
+		for i, req := range params.Users {
+			if req.Name == "" {
+				return fmt.Errorf("user %d: name is required", i)
+			}
+		}
+	case jobExportUsers:
+		var params exportUsersParams
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &params); err != nil {
+				return fmt.Errorf("invalid params: %w", err)
+			}
+		}
+		if params.Format != "" && params.Format != "json" && params.Format != "csv" {
+			return fmt.Errorf("unsupported format %q", params.Format)
+		}
+	}
+	return nil
+}
+
+type importUsersParams struct {
+	Users []createUserRequest `json:"users"`
+}
+
+type importUsersResult struct {
+	Created int   `json:"created"`
+	IDs     []int `json:"ids"`
+}
+
+func (m *jobManager) runImport(ctx context.Context, t *tenant, id string, raw json.RawMessage) error {
+	var params importUsersParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return fmt.Errorf("invalid params: %w", err)
+	}
+
+	result := importUsersResult{IDs: make([]int, 0, len(params.Users))}
+	total := len(params.Users)
+	for i, req := range params.Users {
+		// Check between items, so a cancel takes effect promptly and
+		// leaves exactly the users created so far.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if req.Name == "" {
+			return fmt.Errorf("user %d: name is required", i)
+		}
+		u, err := t.store.addUser(req.Name)
+		if err != nil {
+			return fmt.Errorf("user %d: %w", i, err)
+		}
+		result.Created++
+		result.IDs = append(result.IDs, u.ID)
+		m.progress(id, i+1, total)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return m.storeResult(ctx, t, id, "json", "application/json", data)
+}
+
+type exportUsersParams struct {
+	Format string `json:"format"` // "json" (default) or "csv"
+}
+
+func (m *jobManager) runExport(ctx context.Context, t *tenant, id string, raw json.RawMessage) error {
+	var params exportUsersParams
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &params); err != nil {
+			return fmt.Errorf("invalid params: %w", err)
+		}
+	}
+
+	users := t.store.listUsers()
+	total := len(users)
+	m.progress(id, 0, total)
+
+	var buf bytes.Buffer
+	switch params.Format {
+	case "", "json":
+		enc := json.NewEncoder(&buf)
+		for i, u := range users {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			// One JSON object per line, so huge exports can be streamed by the reader.
+			if err := enc.Encode(u); err != nil {
+				return err
+			}
+			m.progress(id, i+1, total)
+		}
+		return m.storeResult(ctx, t, id, "ndjson", "application/x-ndjson", buf.Bytes())
+
+	case "csv":
+		w := csv.NewWriter(&buf)
+		_ = w.Write([]string{"id", "name", "email"})
+		for i, u := range users {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			_ = w.Write([]string{strconv.Itoa(u.ID), u.Name, u.Email})
+			m.progress(id, i+1, total)
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return err
+		}
+		return m.storeResult(ctx, t, id, "csv", "text/csv", buf.Bytes())
+
+	default:
+		return fmt.Errorf("unsupported format %q", params.Format)
+	}
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func registerJobRoutes(mux *http.ServeMux, jobs *jobManager) {
+	mux.HandleFunc("POST /jobs", jobs.handleSubmit)
+	mux.HandleFunc("GET /jobs", func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusOK, jobs.list(tenantFrom(r.Context()).id))
+	})
+	mux.HandleFunc("GET /jobs/{id}", jobs.handleGet)
+	mux.HandleFunc("DELETE /jobs/{id}", jobs.handleCancel)
+	mux.HandleFunc("GET /jobs/{id}/result", jobs.handleResult)
+}
+
+type submitJobRequest struct {
+	Type   string          `json:"type"`
+	Params json.RawMessage `json:"params"`
+}
+
+func (m *jobManager) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxJobBody)
+
+	var req submitJobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.Type != jobImportUsers && req.Type != jobExportUsers {
+		http.Error(w, "type must be "+jobImportUsers+" or "+jobExportUsers, http.StatusBadRequest)
+		return
+	}
+	if err := validateJobParams(req.Type, req.Params); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := m.submit(tenantFrom(r.Context()).id, req.Type, req.Params)
+	if errors.Is(err, errJobQueueFull) || errors.Is(err, errJobsClosed) {
+		w.Header().Set("Retry-After", "5")
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/jobs/"+job.ID)
+	respondJSON(w, http.StatusAccepted, job)
+}
+
+func (m *jobManager) handleGet(w http.ResponseWriter, r *http.Request) {
+	rec, err := m.get(tenantFrom(r.Context()).id, r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	respondJSON(w, http.StatusOK, rec.Job)
+}
+
+func (m *jobManager) handleCancel(w http.ResponseWriter, r *http.Request) {
+	job, err := m.cancel(tenantFrom(r.Context()).id, r.PathValue("id"))
+	switch {
+	case errors.Is(err, errJobNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errJobFinished):
+		http.Error(w, err.Error(), http.StatusConflict)
+	default:
+		respondJSON(w, http.StatusAccepted, job)
+	}
+}
+
+func (m *jobManager) handleResult(w http.ResponseWriter, r *http.Request) {
+	rec, err := m.get(tenantFrom(r.Context()).id, r.PathValue("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if rec.Status != jobSucceeded || rec.ResultKey == "" {
+		http.Error(w, "job has no result (status "+string(rec.Status)+")", http.StatusConflict)
+		return
+	}
+
+	blob, err := m.blobs.Open(r.Context(), rec.ResultKey)
+	if err != nil {
+		log.Printf("open job result %s: %v", rec.ResultKey, err)
+		http.Error(w, "result not available", http.StatusGone)
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", rec.ResultContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(rec.ResultKey)+`"`)
+	http.ServeContent(w, r, "", blob.ModTime, blob)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails. The server only talks to this interface, so local
+// development and tests can swap SMTP for something that doesn't leave the machine.
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// smtpMailer delivers through an SMTP relay using net/smtp.
+type smtpMailer struct {
+	addr string // host:port
+	from string
+	auth smtp.Auth // nil means no AUTH
+}
+
+func newSMTPMailer(addr, from, username, password string) *smtpMailer {
+	m := &smtpMailer{addr: addr, from: from}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		// PlainAuth refuses to send credentials over an unencrypted connection
+		// unless the server is localhost.
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg Message) error {
+	// smtp.SendMail has no context support, so run it in a goroutine and stop
+	// waiting when the context is done.
+	errCh := make(chan error, 1)
+	data, err := formatMessage(m.from, msg)
+	if err != nil {
+		return err
+	}
+	go func() {
+		errCh <- smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, data)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// formatMessage renders an RFC 5322 message with CRLF line endings. It
+// refuses header values with line breaks, which would let whoever chose
+// them add headers of their own.
+func formatMessage(from string, msg Message) ([]byte, error) {
+	for _, v := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("mail header %q contains a line break", v)
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String()), nil
+}
+
+// outboxMailer logs every message or, when dir is set, writes it to dir as
+// an .eml file. Nothing is actually delivered.
+type outboxMailer struct {
+	dir string
+	// count numbers the files, so two messages in the same second don't
+	// overwrite each other.
+	count atomic.Uint64
+}
+
+func newOutboxMailer(dir string) *outboxMailer {
+	return &outboxMailer{dir: dir}
+}
+
+func (m *outboxMailer) Send(_ context.Context, msg Message) error {
+	data, err := formatMessage("rest-playground@localhost", msg)
+	if err != nil {
+		return err
+	}
+	if m.dir == "" {
+		log.Printf("mail to=%s subject=%q\n%s", msg.To, msg.Subject, msg.Body)
+		return nil
+	}
+
+	if err := os.MkdirAll(m.dir, 0o755); err != nil {
+		return fmt.Errorf("create mail dir: %w", err)
+	}
+	name := fmt.Sprintf("%s-%03d.eml", time.Now().Format("20060102T150405"), m.count.Add(1))
+	return os.WriteFile(filepath.Join(m.dir, name), data, 0o644)
+}
+
+// switchMailer forwards to a Mailer that can be replaced while the server
+// runs, so SMTP settings can change without rebuilding every authService.
+type switchMailer struct {
+	mu     sync.RWMutex
+	mailer Mailer
+}
+
+func (m *switchMailer) set(mailer Mailer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.mailer = mailer
+}
+
+func (m *switchMailer) Send(ctx context.Context, msg Message) error {
+	m.mu.RLock()
+	mailer := m.mailer
+	m.mu.RUnlock()
+	return mailer.Send(ctx, msg)
+}
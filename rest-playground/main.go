@@ -1,48 +1,119 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 // User represents a simple user entity.
 type User struct {
-	ID   int    `json:"id"`
-	Name string `json:"name"`
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email,omitempty"`
+	// EmailVerified is set once the user redeems the link from the verification email.
+	EmailVerified bool    `json:"email_verified,omitempty"`
+	Avatar        *Avatar `json:"avatar,omitempty"`
 }
 
+var errUserNotFound = errors.New("user not found")
+
 // userStore is an in-memory store with basic concurrency protection.
 type userStore struct {
 	// sync.Mutex is Go’s simplest mutual exclusion lock: only one goroutine can hold it at a time.
 	mu     sync.Mutex
 	users  []User
 	nextID int
+
+	// Teams live in the same store (and behind the same mutex) as users,
+	// so deleting a user and dropping their memberships happens atomically.
+	teams      []Team
+	nextTeamID int
+	// members maps team ID -> set of user IDs.
+	members map[int]map[int]struct{}
+
+	// modified and version change on every mutation. They drive Last-Modified
+	// and ETag on list responses, so clients can revalidate cheaply.
+	modified time.Time
+	version  uint64
+
+	// quota caps the number of users and teams; see tenantQuota.
+	quota tenantQuota
+
+	// onDelete, if set, is called with every deleted user, after the lock is
+	// released. It cleans up what lives outside the store, like avatar blobs.
+	onDelete func(User)
 }
 
 func newUserStore() *userStore {
 	return &userStore{
-		users:  make([]User, 0),
-		nextID: 1,
+		users:      make([]User, 0),
+		nextID:     1,
+		teams:      make([]Team, 0),
+		nextTeamID: 1,
+		members:    make(map[int]map[int]struct{}),
+		modified:   time.Now(),
 	}
 }
 
+// touch records that the store changed. Callers must hold s.mu.
+func (s *userStore) touch() {
+	s.modified = time.Now()
+	s.version++
+}
+
+// setQuota changes the store's limits. Existing data over a lowered limit
+// stays; only new inserts are refused.
+func (s *userStore) setQuota(q tenantQuota) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.quota = q
+}
+
+// lastModified returns when the store last changed and its version counter.
+func (s *userStore) lastModified() (time.Time, uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.modified, s.version
+}
+
 // addUser inserts a new user with a generated ID.
-func (s *userStore) addUser(name string) User {
+func (s *userStore) addUser(name string) (User, error) {
+	return s.addUserWithEmail(name, "")
+}
+
+// addUserWithEmail inserts a new user with a generated ID and an email address.
+// It fails with errQuotaExceeded once the store holds quota.MaxUsers users.
+func (s *userStore) addUserWithEmail(name, email string) (User, error) {
 	// Lock the mutex to ensure exclusive access to the users slice.
 	s.mu.Lock()
 	// Release the lock when the function returns.
 	defer s.mu.Unlock()
 
+	if s.quota.MaxUsers > 0 && len(s.users) >= s.quota.MaxUsers {
+		return User{}, errQuotaExceeded
+	}
+
 	u := User{
-		ID:   s.nextID,
-		Name: name,
+		ID:    s.nextID,
+		Name:  name,
+		Email: email,
 	}
 	s.nextID++
 	s.users = append(s.users, u)
-	return u
+	s.touch()
+	return u, nil
 }
 
 // listUsers returns a copy of all users.
@@ -61,55 +132,367 @@ func (s *userStore) listUsers() []User {
 	return result
 }
 
-func main() {
-	log.Println("starting REST playground on :8080")
+// getUser looks up a single user by ID.
+func (s *userStore) getUser(id int) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	store := newUserStore()
+	i := s.userIndex(id)
+	if i < 0 {
+		return User{}, errUserNotFound
+	}
+	return s.users[i], nil
+}
 
-	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("OK"))
-	})
+// updateUser renames an existing user.
+func (s *userStore) updateUser(id int, name string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	http.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			handleCreateUser(w, r, store)
-		case http.MethodGet:
-			handleListUsers(w, r, store)
-		default:
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	i := s.userIndex(id)
+	if i < 0 {
+		return User{}, errUserNotFound
+	}
+	s.users[i].Name = name
+	s.touch()
+	return s.users[i], nil
+}
+
+// findUsers returns one page of users whose name or email contains query
+// (case-insensitive), plus the total number of matches.
+// An empty query matches everyone; limit <= 0 means no limit.
+func (s *userStore) findUsers(query string, offset, limit int) ([]User, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	query = strings.ToLower(query)
+	matches := make([]User, 0)
+	for _, u := range s.users {
+		if query == "" ||
+			strings.Contains(strings.ToLower(u.Name), query) ||
+			strings.Contains(strings.ToLower(u.Email), query) {
+			matches = append(matches, u)
 		}
-	})
+	}
+
+	total := len(matches)
+	offset = min(max(offset, 0), total)
+	end := total
+	if limit > 0 {
+		end = min(offset+limit, total)
+	}
+	// Copy the page so the caller doesn't keep the whole matches slice alive.
+	// Never nil: an empty page must encode as [], not null.
+	return append(make([]User, 0, end-offset), matches[offset:end]...), total
+}
+
+// deleteUser removes a user and every team membership they had.
+func (s *userStore) deleteUser(id int) error {
+	s.mu.Lock()
+	i := s.userIndex(id)
+	if i < 0 {
+		s.mu.Unlock()
+		return errUserNotFound
+	}
+	u := s.users[i]
+	s.users = append(s.users[:i], s.users[i+1:]...)
 
-	// Optional: individual user by id (GET /users/{id})
-	http.HandleFunc("/users/", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	// Referential integrity: a deleted user can't stay a member of anything.
+	for _, set := range s.members {
+		delete(set, id)
+	}
+	s.touch()
+	s.mu.Unlock()
+
+	if s.onDelete != nil {
+		s.onDelete(u)
+	}
+	return nil
+}
+
+// markEmailVerified flags the user's email address as confirmed.
+func (s *userStore) markEmailVerified(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.userIndex(id)
+	if i < 0 {
+		return errUserNotFound
+	}
+	s.users[i].EmailVerified = true
+	s.touch()
+	return nil
+}
+
+// userIndex returns the position of the user in s.users, or -1.
+// Callers must hold s.mu.
+func (s *userStore) userIndex(id int) int {
+	for i, u := range s.users {
+		if u.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func main() {
+	// Subcommands come before any flags: rest-playground gen-certs -out certs
+	if len(os.Args) > 1 {
+		subcommands := map[string]func([]string) error{
+			"gen-certs": runGenCerts,
+			"replay":    runReplay,
+		}
+		if run, ok := subcommands[os.Args[1]]; ok {
+			if err := run(os.Args[2:]); err != nil {
+				log.Fatalf("%s: %v", os.Args[1], err)
+			}
 			return
 		}
+	}
+
+	addr := flag.String("addr", ":8080", "address to listen on")
+	tlsCert := flag.String("tls-cert", "", "PEM certificate file; together with -tls-key serves HTTPS")
+	tlsKey := flag.String("tls-key", "", "PEM private key file for -tls-cert")
+	tlsClientCA := flag.String("tls-client-ca", "", "PEM CA bundle; clients must present a certificate signed by it (mTLS)")
+	tlsClientOptional := flag.Bool("tls-client-optional", false, "with -tls-client-ca, verify client certificates only when sent")
+	publicURL := flag.String("public-url", "http://localhost:8080", "base URL used in links sent by email")
+	smtpAddr := flag.String("smtp-addr", "", "SMTP relay host:port; empty writes emails to -mail-dir or the log instead")
+	smtpFrom := flag.String("smtp-from", "rest-playground@localhost", "sender address for outgoing email")
+	smtpUser := flag.String("smtp-user", "", "SMTP username (password is read from $SMTP_PASSWORD)")
+	mailDir := flag.String("mail-dir", "", "directory to drop .eml files into when -smtp-addr is empty")
+	adminEmails := flag.String("admin-emails", "", `comma-separated "tenant:email" of verified users allowed into /admin; a bare email is an admin of the default tenant`)
+	blobDir := flag.String("blob-dir", "data/blobs", "directory for uploaded files such as avatars")
+	jobsFile := flag.String("jobs-file", "", "JSON file to persist background jobs in; empty keeps them in memory")
+	jobWorkers := flag.Int("job-workers", 4, "number of background jobs that may run at once")
+	jobQueue := flag.Int("job-queue", 100, "number of background jobs that may wait for a worker")
+	corsOrigins := flag.String("cors-origins", "", `comma-separated origins allowed to call the API cross-origin ("*" for any)`)
+	corsMethods := flag.String("cors-methods", "GET,POST,PUT,DELETE", "comma-separated methods allowed in CORS requests")
+	corsHeaders := flag.String("cors-headers", "Content-Type,Authorization", "comma-separated request headers allowed in CORS requests")
+	corsCredentials := flag.Bool("cors-credentials", false, "allow cross-origin requests to send cookies")
+	corsMaxAge := flag.Duration("cors-max-age", 10*time.Minute, "how long browsers may cache a preflight answer")
+	tenants := flag.String("tenants", "", "comma-separated tenant IDs to allow; empty creates tenants on first use")
+	maxTenants := flag.Int("max-tenants", 100, "with an empty -tenants, how many tenants besides the default one may be created")
+	tenantMaxUsers := flag.Int("tenant-max-users", 0, "default per-tenant user limit (0 = unlimited)")
+	tenantMaxTeams := flag.Int("tenant-max-teams", 0, "default per-tenant team limit (0 = unlimited)")
+	tenantQuotas := flag.String("tenant-quotas", "", "per-tenant overrides as tenant=maxUsers:maxTeams, comma-separated")
+	minFreeDisk := flag.Uint64("min-free-disk-mb", 100, "readiness fails when the blob or jobs directory has less free space (MiB)")
+	shutdownDelay := flag.Duration("shutdown-delay", 5*time.Second, "how long /readyz reports not-ready before connections are drained")
+	recordFile := flag.String("record-file", "", "append every request/response pair to this NDJSON file (see the replay subcommand)")
+	recordRedact := flag.String("record-redact-headers", "Authorization,Cookie,Set-Cookie,X-Api-Key", "comma-separated headers whose values are redacted in recordings")
+	recordRedactFields := flag.String("record-redact-fields", "password,token,csrf_token", "comma-separated JSON keys, form fields and query parameters whose values are redacted in recordings")
+	recordMaxBody := flag.Int("record-max-body", 64<<10, "bytes of each request and response body to keep in recordings")
+	recordSkip := flag.String("record-skip-paths", "/health,/livez,/readyz", `comma-separated paths that are never recorded; one ending in "/" also skips everything below it`)
+	shutdownTimeout := flag.Duration("shutdown-timeout", 30*time.Second, "how long to wait for in-flight requests and jobs on shutdown")
+	configFile := flag.String("config", "", "JSON or YAML file overriding the reloadable settings; re-read when it changes")
+	configPoll := flag.Duration("config-poll", 2*time.Second, "how often to check -config for changes")
+	purgeSchedule := flag.String("purge-schedule", "*/10 * * * *", "cron schedule (UTC) for dropping expired sessions and tokens; empty disables it")
+	jobPruneSchedule := flag.String("job-prune-schedule", "30 3 * * *", "cron schedule (UTC) for removing old finished jobs; empty disables it")
+	jobRetention := flag.Duration("job-retention", 7*24*time.Hour, "how long finished jobs and their results are kept")
+	flag.Parse()
+
+	if (*tlsCert == "") != (*tlsKey == "") {
+		log.Fatal("-tls-cert and -tls-key must be set together")
+	}
+	if *tlsClientCA != "" && *tlsCert == "" {
+		log.Fatal("-tls-client-ca needs -tls-cert and -tls-key")
+	}
+
+	quotas, err := parseTenantQuotas(*tenantQuotas)
+	if err != nil {
+		log.Fatalf("-tenant-quotas: %v", err)
+	}
+
+	// The flags are the defaults for the settings that can change at runtime.
+	configs, err := newConfigManager(*configFile, serverConfig{
+		CORS: corsSettings{
+			AllowedOrigins:   splitList(*corsOrigins),
+			AllowedMethods:   splitList(*corsMethods),
+			AllowedHeaders:   splitList(*corsHeaders),
+			AllowCredentials: *corsCredentials,
+			MaxAge:           duration(*corsMaxAge),
+		},
+		Tenants:  tenantSettings{MaxUsers: *tenantMaxUsers, MaxTeams: *tenantMaxTeams, Quotas: quotas},
+		GraphQL:  graphqlSettings{MaxDepth: 15, MaxComplexity: 1000, MaxFields: 500},
+		Features: featureSettings{Registration: true, AdminUI: true, Jobs: true, AvatarUploads: true, GraphQL: true},
+		Mail: mailSettings{
+			SMTPAddr:     *smtpAddr,
+			SMTPFrom:     *smtpFrom,
+			SMTPUser:     *smtpUser,
+			SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+			Dir:          *mailDir,
+		},
+		AdminEmails: splitList(*adminEmails),
+	})
+	if err != nil {
+		log.Fatalf("config: %v", err)
+	}
+	cfg := configs.current()
+
+	mailer := &switchMailer{}
+	configs.onChange(func(c *serverConfig) { mailer.set(c.Mail.newMailer()) })
+
+	registry := newTenantRegistry(mailer, *publicURL, splitList(*tenants), *maxTenants, cfg.Tenants.defaultQuota(), cfg.Tenants.Quotas)
+	configs.onChange(func(c *serverConfig) { registry.setQuotas(c.Tenants.defaultQuota(), c.Tenants.Quotas) })
+
+	blobs, err := newLocalBlobStore(*blobDir)
+	if err != nil {
+		log.Fatalf("blob store: %v", err)
+	}
+
+	// Every handler works on the store of the tenant picked by tenantMiddleware.
+	registerUserRoutes(http.DefaultServeMux)
+	registerTeamRoutes(http.DefaultServeMux)
+	registerAuthRoutes(http.DefaultServeMux)
+	registerVerificationRoutes(http.DefaultServeMux)
+	avatars := &avatarService{blobs: blobs}
+	registry.onUserDeleted(avatars.deleteBlobs)
+	registerAvatarRoutes(http.DefaultServeMux, avatars)
+
+	schema, err := newGraphQLSchema()
+	if err != nil {
+		log.Fatalf("graphql schema: %v", err)
+	}
+	registerGraphQLRoutes(http.DefaultServeMux, &graphqlService{schema: schema, configs: configs})
+
+	var jobRepo jobRepository = memoryJobRepository{}
+	if *jobsFile != "" {
+		jobRepo = fileJobRepository{path: *jobsFile}
+	}
+	jobs, err := newJobManager(registry, blobs, jobRepo, *jobWorkers, *jobQueue)
+	if err != nil {
+		log.Fatalf("jobs: %v", err)
+	}
+	registerJobRoutes(http.DefaultServeMux, jobs)
+
+	sched := newScheduler()
+	if err := sched.add("purge-expired", *purgeSchedule, time.Minute, purgeExpiredTask(registry)); err != nil {
+		log.Fatalf("-purge-schedule: %v", err)
+	}
+	if err := sched.add("prune-jobs", *jobPruneSchedule, 5*time.Minute, pruneJobsTask(jobs, *jobRetention)); err != nil {
+		log.Fatalf("-job-prune-schedule: %v", err)
+	}
 
-		idStr := r.URL.Path[len("/users/"):]
-		id, err := strconv.Atoi(idStr)
+	admin, err := newAdminUI(registry, cfg.AdminEmails)
+	if err != nil {
+		log.Fatalf("admin templates: %v", err)
+	}
+	configs.onChange(func(c *serverConfig) { admin.setAdmins(c.AdminEmails) })
+	registerAdminRoutes(http.DefaultServeMux, admin)
+	http.HandleFunc("GET /admin/config", admin.requireAdmin(configs.handleShow))
+	http.HandleFunc("GET /admin/schedules", admin.requireAdmin(sched.handleStatus))
+	http.HandleFunc("GET /tls/client", handleClientIdentity)
+
+	health := &healthService{}
+	health.register("store", time.Second, storeWritableCheck(registry))
+	health.register("blobs", 0, blobWritableCheck(blobs))
+	health.register("disk:blobs", 0, diskSpaceCheck(*blobDir, *minFreeDisk<<20))
+	if *jobsFile != "" {
+		jobsDir := filepath.Dir(*jobsFile)
+		health.register("jobs-file", 0, dirWritableCheck(jobsDir))
+		health.register("disk:jobs", 0, diskSpaceCheck(jobsDir, *minFreeDisk<<20))
+	}
+
+	limiter := newRateLimiter()
+	configs.onChange(func(c *serverConfig) { limiter.setLimits(c.RateLimit) })
+
+	// Probes sit outside tenantMiddleware and the rate limit: they must
+	// answer even when no default tenant is allowed or a client is throttled.
+	root := http.NewServeMux()
+	registerHealthRoutes(root, health)
+	root.Handle("/", rateLimitMiddleware(limiter, featureMiddleware(configs, tenantMiddleware(registry, http.DefaultServeMux))))
+
+	// Middleware wraps the whole mux; the outermost one runs first.
+	var handler http.Handler = clientIdentityMiddleware(root)
+	if *recordFile != "" {
+		// Inside gzip, so recordings hold plain bodies.
+		rec, err := newRecorder(*recordFile, splitList(*recordRedact), splitList(*recordRedactFields), *recordMaxBody, splitList(*recordSkip))
 		if err != nil {
-			http.Error(w, "invalid id", http.StatusBadRequest)
-			return
+			log.Fatalf("record: %v", err)
 		}
+		defer rec.close()
+		handler = recordMiddleware(rec, handler)
+		log.Printf("recording traffic to %s", *recordFile)
+	}
+	handler = gzipMiddleware(handler)
 
-		users := store.listUsers()
-		for _, u := range users {
-			if u.ID == id {
-				respondJSON(w, http.StatusOK, u)
-				return
-			}
+	// CORS is rebuilt from scratch on every config change.
+	cors := &swapHandler{}
+	configs.onChange(func(c *serverConfig) { cors.set(corsMiddleware(c.CORS.corsConfig(), handler)) })
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           cors,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if *tlsCert != "" {
+		srv.TLSConfig, err = newTLSConfig(*tlsClientCA, *tlsClientOptional)
+		if err != nil {
+			log.Fatalf("tls: %v", err)
 		}
+	}
 
-		http.Error(w, "not found", http.StatusNotFound)
-	})
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go configs.watch(ctx, *configPoll)
+	sched.start()
 
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	serveErr := make(chan error, 1)
+	go func() {
+		if *tlsCert == "" {
+			log.Printf("starting REST playground on %s", *addr)
+			serveErr <- srv.ListenAndServe()
+		} else {
+			log.Printf("starting REST playground on %s (HTTPS, client certificates: %v)", *addr, *tlsClientCA != "")
+			serveErr <- srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+		}
+	}()
+
+	select {
+	case err := <-serveErr:
 		log.Fatalf("server failed: %v", err)
+	case <-ctx.Done():
+	}
+	stop() // a second signal kills the process right away
+
+	// Graceful shutdown: first report not-ready and keep serving for a while,
+	// so load balancers notice and stop sending traffic, then drain.
+	log.Printf("shutting down: not ready, draining in %s", *shutdownDelay)
+	health.startShutdown()
+	time.Sleep(*shutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("http shutdown: %v", err)
 	}
+	if err := sched.stop(shutdownCtx); err != nil {
+		log.Printf("scheduler shutdown: %v", err)
+	}
+	if err := jobs.shutdown(shutdownCtx); err != nil {
+		log.Printf("jobs shutdown: %v", err)
+	}
+	log.Print("shutdown complete")
+}
+
+func registerUserRoutes(mux *http.ServeMux) {
+	// Since Go 1.22 the default mux understands "METHOD /path/{wildcard}" patterns,
+	// and answers 405 Method Not Allowed by itself when only the method differs.
+	mux.HandleFunc("POST /users", func(w http.ResponseWriter, r *http.Request) {
+		handleCreateUser(w, r, storeFor(r))
+	})
+	mux.HandleFunc("GET /users", cacheListMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleListUsers(w, r, storeFor(r))
+	}))
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handleGetUser(w, r, storeFor(r))
+	})
+	mux.HandleFunc("PUT /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handleUpdateUser(w, r, storeFor(r))
+	})
+	mux.HandleFunc("DELETE /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handleDeleteUser(w, r, storeFor(r))
+	})
 }
 
 type createUserRequest struct {
@@ -127,15 +510,118 @@ func handleCreateUser(w http.ResponseWriter, r *http.Request, store *userStore)
 		return
 	}
 
-	u := store.addUser(req.Name)
+	u, err := store.addUser(req.Name)
+	if err != nil {
+		respondStoreError(w, err)
+		return
+	}
 	respondJSON(w, http.StatusCreated, u)
 }
 
-func handleListUsers(w http.ResponseWriter, _ *http.Request, store *userStore) {
-	users := store.listUsers()
+// handleListUsers returns all users, or one page of them with ?limit=&offset=.
+// ?q= filters by name or email. X-Total-Count carries the number of matches
+// before paging, so clients know when to stop.
+func handleListUsers(w http.ResponseWriter, r *http.Request, store *userStore) {
+	q := r.URL.Query()
+	offset, ok := queryInt(w, q.Get("offset"), "offset")
+	if !ok {
+		return
+	}
+	limit, ok := queryInt(w, q.Get("limit"), "limit")
+	if !ok {
+		return
+	}
+
+	users, total := store.findUsers(q.Get("q"), offset, limit)
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
 	respondJSON(w, http.StatusOK, users)
 }
 
+func handleGetUser(w http.ResponseWriter, r *http.Request, store *userStore) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	u, err := store.getUser(id)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	respondJSON(w, http.StatusOK, u)
+}
+
+func handleUpdateUser(w http.ResponseWriter, r *http.Request, store *userStore) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	var req createUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+
+	u, err := store.updateUser(id, req.Name)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	respondJSON(w, http.StatusOK, u)
+}
+
+func handleDeleteUser(w http.ResponseWriter, r *http.Request, store *userStore) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	if err := store.deleteUser(id); err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var result []string
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
+// pathID parses the named path wildcard as an int and writes a 400 if it isn't one.
+func pathID(w http.ResponseWriter, r *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue(name))
+	if err != nil {
+		http.Error(w, "invalid "+name, http.StatusBadRequest)
+		return 0, false
+	}
+	return id, true
+}
+
+// queryInt parses an optional non-negative query parameter; empty means 0.
+func queryInt(w http.ResponseWriter, value, name string) (int, bool) {
+	if value == "" {
+		return 0, true
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		http.Error(w, "invalid "+name, http.StatusBadRequest)
+		return 0, false
+	}
+	return n, true
+}
+
 func respondJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Maintenance tasks for the scheduler. Each returns a one-line summary that
+// shows up as the result of its last run at /admin/schedules.
+
+// purgeExpiredTask drops expired sessions, one-time tokens and lockout
+// records in every tenant. Nothing else would ever free the ones nobody
+// presents again.
+func purgeExpiredTask(reg *tenantRegistry) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		now := time.Now()
+		var sessions, tokens, failures int
+		for _, t := range reg.all() {
+			if err := ctx.Err(); err != nil {
+				return "", err
+			}
+			s, f := t.auth.purgeExpired(now)
+			sessions += s
+			failures += f
+			tokens += t.auth.tokens.purgeExpired(now)
+		}
+		return fmt.Sprintf("removed %d sessions, %d tokens, %d lockout records", sessions, tokens, failures), nil
+	}
+}
+
+// pruneJobsTask removes jobs that finished more than retention ago, along
+// with their result files.
+func pruneJobsTask(jobs *jobManager, retention time.Duration) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		n, err := jobs.pruneFinished(ctx, time.Now().Add(-retention))
+		return fmt.Sprintf("removed %d finished jobs", n), err
+	}
+}
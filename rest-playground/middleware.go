@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// corsConfig controls which browser origins may call the API.
+type corsConfig struct {
+	// AllowedOrigins may contain "*" to allow any origin.
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	ExposedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+func (c corsConfig) originAllowed(origin string) bool {
+	return slices.Contains(c.AllowedOrigins, "*") || slices.Contains(c.AllowedOrigins, origin)
+}
+
+// corsMiddleware adds CORS headers for allowed origins and answers preflight
+// requests itself; the mux would otherwise reply 405 to OPTIONS.
+func corsMiddleware(cfg corsConfig, next http.Handler) http.Handler {
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	exposed := strings.Join(cfg.ExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+		// The answer depends on Origin, so caches must not mix them up.
+		w.Header().Add("Vary", "Origin")
+
+		if origin == "" || !cfg.originAllowed(origin) {
+			if preflight {
+				// No CORS headers: the browser will block the actual request.
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		// With credentials the spec forbids "*", so always echo the concrete origin.
+		if slices.Contains(cfg.AllowedOrigins, "*") && !cfg.AllowCredentials {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if cfg.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if exposed != "" {
+				h.Set("Access-Control-Expose-Headers", exposed)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		h.Set("Access-Control-Allow-Methods", methods)
+		h.Set("Access-Control-Allow-Headers", headers)
+		h.Set("Access-Control-Max-Age", maxAge)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// gzipMinSize is the smallest body worth compressing; below roughly one
+// packet the gzip header and CPU cost outweigh the savings.
+const gzipMinSize = 1024
+
+var gzipWriterPool = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// gzipMiddleware compresses responses for clients that accept gzip.
+func gzipMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r.Header.Get("Accept-Encoding")) || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.finish()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip parses Accept-Encoding, honouring "gzip;q=0" as a refusal.
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.TrimSpace(coding)
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		return q > 0
+	}
+	return false
+}
+
+// gzipResponseWriter holds back the first gzipMinSize bytes to decide whether
+// compressing is worth it, then either streams through a gzip.Writer or
+// writes the buffered bytes as they are.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	buf      []byte
+	decided  bool
+	compress bool
+	gz       *gzip.Writer
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.status != 0 {
+		return
+	}
+	g.status = status
+	if !g.compressible() {
+		g.decide(false)
+	}
+}
+
+func (g *gzipResponseWriter) Write(p []byte) (int, error) {
+	if g.status == 0 {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.decided {
+		if g.compress {
+			return g.gz.Write(p)
+		}
+		return g.ResponseWriter.Write(p)
+	}
+
+	g.buf = append(g.buf, p...)
+	if len(g.buf) >= gzipMinSize {
+		if err := g.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// compressible reports whether this response may be gzipped at all.
+func (g *gzipResponseWriter) compressible() bool {
+	h := g.Header()
+	switch {
+	case g.status < 200, g.status == http.StatusNoContent, g.status == http.StatusNotModified:
+		return false
+	case h.Get("Content-Encoding") != "", h.Get("Content-Range") != "":
+		// Already encoded, or a byte range of the uncompressed representation.
+		return false
+	}
+
+	ct := h.Get("Content-Type")
+	return strings.HasPrefix(ct, "text/") ||
+		strings.Contains(ct, "json") ||
+		strings.Contains(ct, "xml") ||
+		strings.Contains(ct, "javascript") ||
+		ct == "" // not known until decide sniffs the buffered body
+}
+
+// decide commits the headers and flushes whatever was buffered.
+func (g *gzipResponseWriter) decide(compress bool) error {
+	if g.decided {
+		return nil
+	}
+	g.decided = true
+
+	// net/http would sniff the gzipped bytes and call everything
+	// application/x-gzip, so sniff the plain body here instead. With nothing
+	// buffered there is nothing to sniff: leave it to net/http uncompressed.
+	if compress && g.Header().Get("Content-Type") == "" {
+		if len(g.buf) == 0 {
+			compress = false
+		} else {
+			g.Header().Set("Content-Type", http.DetectContentType(g.buf))
+			compress = g.compressible()
+		}
+	}
+	g.compress = compress
+
+	if compress {
+		h := g.Header()
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		g.gz = gzipWriterPool.Get().(*gzip.Writer)
+		g.gz.Reset(g.ResponseWriter)
+	}
+
+	g.ResponseWriter.WriteHeader(g.status)
+	if len(g.buf) == 0 {
+		return nil
+	}
+	var err error
+	if compress {
+		_, err = g.gz.Write(g.buf)
+	} else {
+		_, err = g.ResponseWriter.Write(g.buf)
+	}
+	g.buf = nil
+	return err
+}
+
+// finish runs after the handler returns.
+func (g *gzipResponseWriter) finish() {
+	if g.status == 0 {
+		// The handler wrote nothing; let net/http send its default 200.
+		return
+	}
+	if !g.decided {
+		_ = g.decide(false)
+	}
+	if g.gz != nil {
+		_ = g.gz.Close()
+		gzipWriterPool.Put(g.gz)
+	}
+}
+
+// Flush forces a decision so streaming handlers still stream.
+func (g *gzipResponseWriter) Flush() {
+	if g.status == 0 {
+		g.WriteHeader(http.StatusOK)
+	}
+	_ = g.decide(g.compressible())
+	if g.gz != nil {
+		_ = g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := g.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("hijacking not supported")
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+// listCacheControl makes clients revalidate list responses every time; with
+// Last-Modified/ETag that is a cheap 304 when nothing changed.
+const listCacheControl = "private, no-cache"
+
+// cacheListMiddleware adds Cache-Control, Last-Modified and ETag to list
+// responses, all derived from the last mutation of the request tenant's
+// store, and answers conditional requests with 304 Not Modified without
+// running the handler.
+func cacheListMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		modified, version := storeFor(r).lastModified()
+		// The version counter catches several changes within the same second,
+		// which Last-Modified (one-second resolution) cannot tell apart.
+		etag := `W/"` + strconv.FormatUint(version, 10) + `"`
+
+		h := w.Header()
+		h.Set("Cache-Control", listCacheControl)
+		h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		h.Set("ETag", etag)
+
+		if notModified(r, etag, modified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// notModified evaluates If-None-Match, falling back to If-Modified-Since
+// only when no ETag condition was sent (RFC 9110, section 13.2.2).
+func notModified(r *http.Request, etag string, modified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// HTTP dates only have one-second resolution, so two changes within the
+	// same second look alike here. Browsers send If-None-Match whenever they
+	// got an ETag, which is exact, so this fallback only serves simpler clients.
+	return !modified.Truncate(time.Second).After(ims)
+}
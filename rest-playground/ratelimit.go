@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxRateBuckets bounds the memory used by rateLimiter. Past it, buckets that
+// have refilled completely are dropped: forgetting them changes nothing.
+const maxRateBuckets = 10000
+
+// rateLimiter is a token bucket per client IP. Its limits can be changed
+// at any time; existing buckets keep their tokens and refill at the new rate.
+type rateLimiter struct {
+	mu       sync.Mutex
+	settings rateLimitSettings
+	buckets  map[string]*rateBucket
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: make(map[string]*rateBucket)}
+}
+
+func (l *rateLimiter) setLimits(s rateLimitSettings) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.settings = s
+	if !s.Enabled {
+		clear(l.buckets)
+	}
+}
+
+// allow takes a token from key's bucket. When the bucket is empty it returns
+// how long until the next token arrives.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	s := l.settings
+	if !s.Enabled {
+		return true, 0
+	}
+	burst := float64(s.Burst)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRateBuckets {
+			l.dropFull(now)
+		}
+		b = &rateBucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(burst, b.tokens+now.Sub(b.last).Seconds()*s.RequestsPerSecond)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / s.RequestsPerSecond * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// dropFull forgets buckets that would be full by now. Callers must hold l.mu.
+func (l *rateLimiter) dropFull(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.settings.RequestsPerSecond >= float64(l.settings.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// rateLimitMiddleware answers 429 Too Many Requests once a client IP runs out
+// of tokens. The address comes from the connection, not X-Forwarded-For,
+// which any client could set.
+func rateLimitMiddleware(l *rateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		if ok, wait := l.allow(host, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// redactedValue replaces the values of redacted headers and body fields in
+// recordings.
+const redactedValue = "REDACTED"
+
+// recordedMessage is the request or response half of a recorded exchange.
+// Text bodies are stored as they are, anything else base64-encoded.
+type recordedMessage struct {
+	Method        string              `json:"method,omitempty"`
+	URL           string              `json:"url,omitempty"` // path and query only
+	Status        int                 `json:"status,omitempty"`
+	Header        map[string][]string `json:"header"`
+	Body          string              `json:"body,omitempty"`
+	BodyBase64    string              `json:"body_base64,omitempty"`
+	BodyTruncated bool                `json:"body_truncated,omitempty"`
+}
+
+// body returns the recorded body bytes.
+func (m recordedMessage) body() ([]byte, error) {
+	if m.BodyBase64 != "" {
+		return base64.StdEncoding.DecodeString(m.BodyBase64)
+	}
+	return []byte(m.Body), nil
+}
+
+// recordedExchange is one line of a recording.
+type recordedExchange struct {
+	Time       time.Time       `json:"time"`
+	DurationMS float64         `json:"duration_ms"`
+	Request    recordedMessage `json:"request"`
+	Response   recordedMessage `json:"response"`
+}
+
+// recorder appends exchanges to an NDJSON file.
+type recorder struct {
+	redact       map[string]bool // canonical header names
+	redactFields map[string]bool // JSON keys, form fields and query parameters
+	maxBody      int
+	// skipPaths are matched exactly, or as a prefix when they end in "/".
+	skipPaths []string
+
+	mu  sync.Mutex
+	f   *os.File
+	buf *bufio.Writer
+}
+
+func newRecorder(path string, redactHeaders, redactFields []string, maxBody int, skipPaths []string) (*recorder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	rec := &recorder{
+		redact:       make(map[string]bool),
+		redactFields: make(map[string]bool),
+		maxBody:      maxBody,
+		skipPaths:    skipPaths,
+		f:            f,
+		buf:          bufio.NewWriter(f),
+	}
+	for _, h := range redactHeaders {
+		rec.redact[http.CanonicalHeaderKey(h)] = true
+	}
+	for _, name := range redactFields {
+		rec.redactFields[name] = true
+	}
+	return rec, nil
+}
+
+// skip reports whether requests for path are left out of the recording.
+func (rec *recorder) skip(path string) bool {
+	for _, p := range rec.skipPaths {
+		if path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(path, p)) {
+			return true
+		}
+	}
+	return false
+}
+
+// write appends one exchange. Each line is flushed right away, so a crash
+// loses at most the exchange that was in flight.
+func (rec *recorder) write(ex recordedExchange) {
+	line, err := json.Marshal(ex)
+	if err != nil {
+		log.Printf("record: %v", err)
+		return
+	}
+
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	_, _ = rec.buf.Write(line)
+	_ = rec.buf.WriteByte('\n')
+	if err := rec.buf.Flush(); err != nil {
+		log.Printf("record: %v", err)
+	}
+}
+
+func (rec *recorder) close() error {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return errors.Join(rec.buf.Flush(), rec.f.Close())
+}
+
+// headers copies h with the configured headers redacted.
+func (rec *recorder) headers(h http.Header) map[string][]string {
+	out := make(map[string][]string, len(h))
+	for name, values := range h {
+		if rec.redact[name] {
+			out[name] = []string{redactedValue}
+			continue
+		}
+		out[name] = slices.Clone(values)
+	}
+	return out
+}
+
+// url returns the request URI with the redacted query parameters replaced.
+func (rec *recorder) url(u *url.URL) string {
+	q := u.Query()
+	if !rec.redactValues(q) {
+		return u.RequestURI()
+	}
+	cp := *u
+	cp.RawQuery = q.Encode()
+	return cp.RequestURI()
+}
+
+// redactValues replaces the redacted fields of v and reports whether there
+// were any.
+func (rec *recorder) redactValues(v url.Values) bool {
+	found := false
+	for name := range v {
+		if rec.redactFields[name] {
+			v[name] = []string{redactedValue}
+			found = true
+		}
+	}
+	return found
+}
+
+// redactJSON replaces the redacted keys anywhere in a decoded JSON value.
+func (rec *recorder) redactJSON(v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if rec.redactFields[k] {
+				v[k] = redactedValue
+				continue
+			}
+			rec.redactJSON(child)
+		}
+	case []any:
+		for _, child := range v {
+			rec.redactJSON(child)
+		}
+	}
+}
+
+// redactBody returns body with the redacted fields of a JSON, form or HTML
+// body replaced. A body of either kind that can't be parsed, e.g. because it was
+// truncated, is replaced as a whole: it may hold a field we can't see.
+func (rec *recorder) redactBody(contentType string, body []byte) []byte {
+	if len(rec.redactFields) == 0 || len(body) == 0 {
+		return body
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		v, err := url.ParseQuery(string(body))
+		if err != nil {
+			return []byte(redactedValue)
+		}
+		if !rec.redactValues(v) {
+			return body
+		}
+		return []byte(v.Encode())
+
+	case strings.HasSuffix(mediaType, "json"):
+		var v any
+		if err := json.Unmarshal(body, &v); err != nil {
+			return []byte(redactedValue)
+		}
+		rec.redactJSON(v)
+		redacted, err := json.Marshal(v)
+		if err != nil {
+			return []byte(redactedValue)
+		}
+		return redacted
+
+	case mediaType == "text/html":
+		// The confirm pages behind emailed links carry the token in a
+		// hidden input.
+		return htmlInputValue.ReplaceAllFunc(body, func(m []byte) []byte {
+			sub := htmlInputValue.FindSubmatch(m)
+			if !rec.redactFields[string(sub[1])] {
+				return m
+			}
+			return []byte(`name="` + string(sub[1]) + `" value="` + redactedValue + `"`)
+		})
+	}
+	return body
+}
+
+// htmlInputValue matches the name and value attributes of a form input as
+// html/template writes them.
+var htmlInputValue = regexp.MustCompile(`name="([^"]*)" value="[^"]*"`)
+
+// encodeBody fills in the body fields of m.
+func encodeBody(m *recordedMessage, body []byte, truncated bool) {
+	m.BodyTruncated = truncated
+	if utf8.Valid(body) {
+		m.Body = string(body)
+	} else {
+		m.BodyBase64 = base64.StdEncoding.EncodeToString(body)
+	}
+}
+
+// recordMiddleware records every request/response pair passing through it.
+// Bodies are capped at maxBody bytes in the recording; the handler and the
+// client still see them in full.
+func recordMiddleware(rec *recorder, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rec.skip(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Read up to the cap, then hand the handler the same bytes followed
+		// by whatever is left unread.
+		head, err := io.ReadAll(io.LimitReader(r.Body, int64(rec.maxBody)+1))
+		if err != nil {
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(head), r.Body), r.Body}
+		reqTruncated := len(head) > rec.maxBody
+		head = head[:min(len(head), rec.maxBody)]
+
+		ex := recordedExchange{
+			Time:    time.Now().UTC(),
+			Request: recordedMessage{Method: r.Method, URL: rec.url(r.URL), Header: rec.headers(r.Header)},
+		}
+		encodeBody(&ex.Request, rec.redactBody(r.Header.Get("Content-Type"), head), reqTruncated)
+
+		rw := &recordingResponseWriter{ResponseWriter: w, maxBody: rec.maxBody}
+		start := time.Now()
+		next.ServeHTTP(rw, r)
+
+		ex.DurationMS = float64(time.Since(start).Microseconds()) / 1000
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+			rw.header = w.Header().Clone()
+		}
+		ex.Response = recordedMessage{Status: rw.status, Header: rec.headers(rw.header)}
+		encodeBody(&ex.Response, rec.redactBody(rw.header.Get("Content-Type"), rw.body.Bytes()), rw.truncated)
+		rec.write(ex)
+	})
+}
+
+// recordingResponseWriter keeps a copy of the status, headers and the first
+// maxBody bytes of the body while passing everything through.
+type recordingResponseWriter struct {
+	http.ResponseWriter
+	maxBody   int
+	status    int
+	header    http.Header // snapshot taken when the header was written
+	body      bytes.Buffer
+	truncated bool
+}
+
+func (rw *recordingResponseWriter) WriteHeader(status int) {
+	if rw.status == 0 {
+		rw.status = status
+		rw.header = rw.Header().Clone()
+	}
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingResponseWriter) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.WriteHeader(http.StatusOK)
+	}
+	if room := rw.maxBody - rw.body.Len(); room > 0 {
+		rw.body.Write(p[:min(len(p), room)])
+		rw.truncated = rw.truncated || len(p) > room
+	} else if len(p) > 0 {
+		rw.truncated = true
+	}
+	return rw.ResponseWriter.Write(p)
+}
+
+func (rw *recordingResponseWriter) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rw *recordingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := rw.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("hijacking not supported")
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rw *recordingResponseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
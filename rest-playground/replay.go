@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxDiffsPerExchange keeps one badly broken response from flooding the output.
+const maxDiffsPerExchange = 20
+
+// replayHeaderSkip are request headers the replay client must not copy:
+// net/http sets them itself, or they only made sense on the original connection.
+// Accept-Encoding is left to the transport so it can decompress gzip for us.
+var replayHeaderSkip = []string{"Accept-Encoding", "Connection", "Content-Length", "Host", "Keep-Alive", "Te", "Trailer", "Transfer-Encoding", "Upgrade"}
+
+// errReplayMismatch means the replay ran, but some responses differed.
+var errReplayMismatch = errors.New("responses differed")
+
+// runReplay implements the "replay" subcommand: it sends every request of a
+// recording (see recordMiddleware) to another instance and diffs the answers.
+func runReplay(args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	target := fs.String("target", "http://localhost:8080", "base URL of the instance to replay against")
+	ignore := fs.String("ignore", "", `comma-separated JSON paths to leave out of body comparisons, e.g. "id,[*].id,avatar.updated_at"`)
+	timeout := fs.Duration("timeout", 10*time.Second, "timeout for each request")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: rest-playground replay [flags] recording.ndjson")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var ignored [][]string
+	for _, p := range splitList(*ignore) {
+		ignored = append(ignored, parseJSONPath(p))
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// Recorded cookies are redacted, so sessions can't be copied from the
+	// recording. The jar picks up the cookies the target hands out instead,
+	// which makes a recorded login followed by /auth/me work on replay, as
+	// long as the password was recorded, i.e. with -record-redact-fields "".
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Timeout: *timeout,
+		Jar:     jar,
+		// Compare redirects as recorded instead of following them.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	base := strings.TrimSuffix(*target, "/")
+
+	var replayed, matched, differed, skipped int
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var ex recordedExchange
+		if err := json.Unmarshal(sc.Bytes(), &ex); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		label := fmt.Sprintf("#%d %s %s", line, ex.Request.Method, ex.Request.URL)
+
+		if ex.Request.BodyTruncated {
+			fmt.Printf("SKIP %s: request body was truncated when recorded\n", label)
+			skipped++
+			continue
+		}
+
+		got, body, err := replayOne(client, base, ex.Request)
+		replayed++
+		if err != nil {
+			fmt.Printf("FAIL %s: %v\n", label, err)
+			differed++
+			continue
+		}
+
+		diffs := diffResponses(ex.Response, got, body, ignored)
+		if len(diffs) == 0 {
+			matched++
+			continue
+		}
+		differed++
+		fmt.Printf("DIFF %s\n", label)
+		for i, d := range diffs {
+			if i == maxDiffsPerExchange {
+				fmt.Printf("  ... and %d more\n", len(diffs)-i)
+				break
+			}
+			fmt.Printf("  %s\n", d)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+
+	fmt.Printf("replayed %d, matched %d, differed %d, skipped %d\n", replayed, matched, differed, skipped)
+	if differed > 0 {
+		return errReplayMismatch
+	}
+	return nil
+}
+
+// replayOne sends one recorded request and reads the whole response.
+func replayOne(client *http.Client, base string, rec recordedMessage) (*http.Response, []byte, error) {
+	body, err := rec.body()
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequest(rec.Method, base+rec.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, values := range rec.Header {
+		if slices.Contains(replayHeaderSkip, name) || slices.Contains(values, redactedValue) {
+			continue
+		}
+		req.Header[name] = values
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	return resp, respBody, err
+}
+
+// diffResponses lists how got differs from the recorded response. Bodies are
+// compared as JSON when both sides are JSON, with the ignored paths removed.
+func diffResponses(want recordedMessage, got *http.Response, gotBody []byte, ignored [][]string) []string {
+	var diffs []string
+	if want.Status != got.StatusCode {
+		diffs = append(diffs, fmt.Sprintf("status: %d != %d", want.Status, got.StatusCode))
+	}
+
+	wantType, _, _ := mime.ParseMediaType(firstHeader(want.Header, "Content-Type"))
+	gotType, _, _ := mime.ParseMediaType(got.Header.Get("Content-Type"))
+	if wantType != gotType {
+		diffs = append(diffs, fmt.Sprintf("content-type: %q != %q", wantType, gotType))
+		return diffs
+	}
+
+	wantBody, err := want.body()
+	if err != nil {
+		return append(diffs, "recorded body: "+err.Error())
+	}
+	if want.BodyTruncated {
+		// Only the recorded prefix can be compared.
+		gotBody = gotBody[:min(len(gotBody), len(wantBody))]
+	}
+
+	var wantJSON, gotJSON any
+	if strings.Contains(wantType, "json") && !want.BodyTruncated &&
+		json.Unmarshal(wantBody, &wantJSON) == nil && json.Unmarshal(gotBody, &gotJSON) == nil {
+		for _, path := range ignored {
+			wantJSON = removeJSONPath(wantJSON, path)
+			gotJSON = removeJSONPath(gotJSON, path)
+		}
+		diffJSON("$", wantJSON, gotJSON, &diffs)
+		return diffs
+	}
+
+	if !bytes.Equal(wantBody, gotBody) {
+		diffs = append(diffs, fmt.Sprintf("body: %d bytes recorded, %d bytes replayed, contents differ", len(wantBody), len(gotBody)))
+	}
+	return diffs
+}
+
+func firstHeader(h map[string][]string, name string) string {
+	if v := h[name]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+// parseJSONPath splits a path such as "$.users[*].id" or "avatar.updated_at"
+// into segments. "*" matches every array element or object key.
+func parseJSONPath(p string) []string {
+	p = strings.TrimPrefix(p, "$")
+	p = strings.NewReplacer("[", ".", "]", "").Replace(p)
+	var segs []string
+	for _, s := range strings.Split(p, ".") {
+		if s != "" {
+			segs = append(segs, s)
+		}
+	}
+	return segs
+}
+
+// removeJSONPath deletes the value at path from v and returns v.
+func removeJSONPath(v any, path []string) any {
+	if len(path) == 0 {
+		return nil
+	}
+	seg, rest := path[0], path[1:]
+
+	switch node := v.(type) {
+	case map[string]any:
+		for key := range node {
+			if seg != "*" && seg != key {
+				continue
+			}
+			if len(rest) == 0 {
+				delete(node, key)
+			} else {
+				node[key] = removeJSONPath(node[key], rest)
+			}
+		}
+	case []any:
+		for i := range node {
+			if seg != "*" && seg != strconv.Itoa(i) {
+				continue
+			}
+			if len(rest) == 0 {
+				node[i] = nil // keep indexes stable for the elements after it
+			} else {
+				node[i] = removeJSONPath(node[i], rest)
+			}
+		}
+	}
+	return v
+}
+
+// diffJSON appends one line per differing leaf, e.g. `$[0].name: "a" != "b"`.
+func diffJSON(path string, want, got any, diffs *[]string) {
+	switch w := want.(type) {
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(w)+len(g))
+		for k := range w {
+			keys = append(keys, k)
+		}
+		for k := range g {
+			if _, dup := w[k]; !dup {
+				keys = append(keys, k)
+			}
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			wv, inWant := w[k]
+			gv, inGot := g[k]
+			switch {
+			case !inWant:
+				*diffs = append(*diffs, fmt.Sprintf("%s.%s: missing in recording, replayed %s", path, k, compactJSON(gv)))
+			case !inGot:
+				*diffs = append(*diffs, fmt.Sprintf("%s.%s: recorded %s, missing in replay", path, k, compactJSON(wv)))
+			default:
+				diffJSON(path+"."+k, wv, gv, diffs)
+			}
+		}
+		return
+
+	case []any:
+		g, ok := got.([]any)
+		if !ok {
+			break
+		}
+		if len(w) != len(g) {
+			*diffs = append(*diffs, fmt.Sprintf("%s: %d elements recorded, %d replayed", path, len(w), len(g)))
+		}
+		for i := range min(len(w), len(g)) {
+			diffJSON(path+"["+strconv.Itoa(i)+"]", w[i], g[i], diffs)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(want, got) {
+		*diffs = append(*diffs, fmt.Sprintf("%s: %s != %s", path, compactJSON(want), compactJSON(got)))
+	}
+}
+
+func compactJSON(v any) string {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	const maxLen = 80
+	if len(data) > maxLen {
+		return string(data[:maxLen]) + "..."
+	}
+	return string(data)
+}
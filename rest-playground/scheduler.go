@@ -0,0 +1,431 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cronSchedule is a parsed standard 5-field cron expression:
+//
+//	minute hour day-of-month month day-of-week
+//
+// Each field is a bit set of the values it matches. Schedules are evaluated
+// in UTC, so they don't jump around at daylight saving changes.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar and dowStar record a day field starting with "*". As in Vixie
+	// cron, when both day fields are restricted a day matching either is enough.
+	domStar, dowStar bool
+}
+
+type cronField struct {
+	name     string
+	min, max int
+	names    []string // names[i] stands for min+i
+}
+
+var (
+	cronMinute = cronField{name: "minute", min: 0, max: 59}
+	cronHour   = cronField{name: "hour", min: 0, max: 23}
+	cronDOM    = cronField{name: "day of month", min: 1, max: 31}
+	cronMonth  = cronField{name: "month", min: 1, max: 12,
+		names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}}
+	// 7 is accepted as Sunday too and folded onto 0 after parsing.
+	cronDOW = cronField{name: "day of week", min: 0, max: 7,
+		names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}}
+)
+
+// cronMacros are the usual shorthands.
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseCron parses expressions such as "*/15 * * * *", "0 3 * * mon-fri"
+// or "@daily".
+func parseCron(spec string) (*cronSchedule, error) {
+	expr := strings.TrimSpace(spec)
+	if m, ok := cronMacros[strings.ToLower(expr)]; ok {
+		expr = m
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron %q: want 5 fields, got %d", spec, len(fields))
+	}
+
+	s := &cronSchedule{domStar: strings.HasPrefix(fields[2], "*"), dowStar: strings.HasPrefix(fields[4], "*")}
+	var err error
+	for i, dst := range []struct {
+		bits  *uint64
+		field cronField
+	}{{&s.minute, cronMinute}, {&s.hour, cronHour}, {&s.dom, cronDOM}, {&s.month, cronMonth}, {&s.dow, cronDOW}} {
+		if *dst.bits, err = dst.field.parse(fields[i]); err != nil {
+			return nil, fmt.Errorf("cron %q: %w", spec, err)
+		}
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow = s.dow&^(1<<7) | 1
+	}
+	return s, nil
+}
+
+// parse turns one field ("*", "5", "1-5", "*/10", "mon,wed,fri", ...) into a bit set.
+func (f cronField) parse(expr string) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(expr, ",") {
+		rangeExpr, stepExpr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepExpr)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("%s: invalid step %q", f.name, stepExpr)
+			}
+			step = n
+		}
+
+		lo, hi := f.min, f.max
+		switch {
+		case rangeExpr == "*":
+		case strings.Contains(rangeExpr, "-"):
+			a, b, _ := strings.Cut(rangeExpr, "-")
+			var err error
+			if lo, err = f.value(a); err != nil {
+				return 0, err
+			}
+			if hi, err = f.value(b); err != nil {
+				return 0, err
+			}
+			if lo > hi {
+				return 0, fmt.Errorf("%s: range %q goes backwards", f.name, rangeExpr)
+			}
+		default:
+			v, err := f.value(rangeExpr)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			// "5/15" means from 5 to the end in steps of 15.
+			if !hasStep {
+				hi = v
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			set |= 1 << v
+		}
+	}
+	return set, nil
+}
+
+func (f cronField) value(s string) (int, error) {
+	for i, name := range f.names {
+		if strings.EqualFold(s, name) {
+			return f.min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("%s: %q is not between %d and %d", f.name, s, f.min, f.max)
+	}
+	return v, nil
+}
+
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<t.Day()) != 0
+	dow := s.dow&(1<<int(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// next returns the first time after t the schedule fires, or the zero time
+// if it never does (e.g. "0 0 31 2 *").
+func (s *cronSchedule) next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	// Every valid schedule fires within 8 years (Feb 29 on a given weekday
+	// is the rarest), so give up after that instead of looping forever.
+	limit := t.AddDate(8, 0, 0)
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		switch {
+		case s.month&(1<<int(m)) == 0:
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, time.UTC)
+		case !s.dayMatches(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+		case s.hour&(1<<t.Hour()) == 0:
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, time.UTC)
+		case s.minute&(1<<t.Minute()) == 0:
+			// Jump straight to the next matching minute in this hour, if any.
+			if later := s.minute >> (t.Minute() + 1); later != 0 {
+				t = t.Add(time.Duration(bits.TrailingZeros64(later)+1) * time.Minute)
+			} else {
+				t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, time.UTC)
+			}
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Run outcomes, as shown at /admin/schedules.
+const (
+	runOK        = "ok"
+	runFailed    = "failed"
+	runTimeout   = "timeout"
+	runCancelled = "cancelled" // interrupted by shutdown
+)
+
+// scheduledTask is one registered task and what happened when it last ran.
+type scheduledTask struct {
+	name     string
+	spec     string
+	schedule *cronSchedule
+	timeout  time.Duration
+	// run does the work and returns a short summary, e.g. "removed 3 sessions".
+	run func(ctx context.Context) (string, error)
+
+	// Guarded by scheduler.mu.
+	running  bool
+	nextRun  time.Time
+	lastRun  *taskRun
+	runs     int
+	overlaps int // runs skipped because the previous one was still going
+}
+
+type taskRun struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	DurationMS int64     `json:"duration_ms"`
+	Status     string    `json:"status"`
+	Result     string    `json:"result,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// scheduler runs tasks on cron schedules. A task never overlaps itself: if
+// it is still running when it comes due again, that run is skipped.
+type scheduler struct {
+	mu       sync.Mutex
+	tasks    []*scheduledTask
+	started  bool
+	stopping bool // set by stop; no run starts after it
+
+	ctx     context.Context // cancelled to interrupt running tasks
+	cancel  context.CancelFunc
+	done    chan struct{} // closed when the loop has exited
+	running sync.WaitGroup
+}
+
+func newScheduler() *scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &scheduler{ctx: ctx, cancel: cancel, done: make(chan struct{})}
+}
+
+// add registers a task. An empty spec leaves it switched off.
+func (s *scheduler) add(name, spec string, timeout time.Duration, run func(ctx context.Context) (string, error)) error {
+	if spec == "" {
+		return nil
+	}
+	sched, err := parseCron(spec)
+	if err != nil {
+		return err
+	}
+	task := &scheduledTask{name: name, spec: spec, schedule: sched, timeout: timeout, run: run}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	task.nextRun = sched.next(time.Now())
+	if task.nextRun.IsZero() {
+		return fmt.Errorf("cron %q never fires", spec)
+	}
+	s.tasks = append(s.tasks, task)
+	return nil
+}
+
+// start runs the scheduling loop until stop is called.
+func (s *scheduler) start() {
+	s.mu.Lock()
+	s.started = true
+	s.mu.Unlock()
+	go s.loop()
+}
+
+func (s *scheduler) loop() {
+	defer close(s.done)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		now := time.Now()
+		s.mu.Lock()
+		if s.stopping {
+			s.mu.Unlock()
+			<-s.ctx.Done()
+			return
+		}
+		var wakeAt time.Time
+		for _, task := range s.tasks {
+			if !task.nextRun.After(now) {
+				s.launch(task, now)
+				task.nextRun = task.schedule.next(now)
+			}
+			if wakeAt.IsZero() || task.nextRun.Before(wakeAt) {
+				wakeAt = task.nextRun
+			}
+		}
+		s.mu.Unlock()
+
+		// With nothing scheduled, sleep until stop.
+		wait := time.Hour
+		if !wakeAt.IsZero() {
+			wait = time.Until(wakeAt)
+		}
+		timer.Reset(wait)
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// launch starts one run in its own goroutine unless the previous run is
+// still going. Callers must hold s.mu.
+func (s *scheduler) launch(task *scheduledTask, now time.Time) {
+	if task.running {
+		task.overlaps++
+		log.Printf("scheduler: %s: previous run still going, skipping", task.name)
+		return
+	}
+	task.running = true
+	s.running.Add(1)
+
+	go func() {
+		defer s.running.Done()
+		ctx, cancel := context.WithTimeout(s.ctx, task.timeout)
+		defer cancel()
+
+		run := &taskRun{StartedAt: now.UTC(), Status: runOK}
+		result, err := safeRun(ctx, task.run)
+		run.FinishedAt = time.Now().UTC()
+		run.DurationMS = run.FinishedAt.Sub(run.StartedAt).Milliseconds()
+		run.Result = result
+		switch {
+		case err == nil:
+		case s.ctx.Err() != nil:
+			run.Status = runCancelled
+			run.Error = err.Error()
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			run.Status = runTimeout
+			run.Error = err.Error()
+		default:
+			run.Status = runFailed
+			run.Error = err.Error()
+		}
+		if err != nil {
+			log.Printf("scheduler: %s: %s: %v", task.name, run.Status, err)
+		}
+
+		s.mu.Lock()
+		task.running = false
+		task.lastRun = run
+		task.runs++
+		s.mu.Unlock()
+	}()
+}
+
+// safeRun keeps a panicking task from taking the whole server down.
+func safeRun(ctx context.Context, run func(context.Context) (string, error)) (result string, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+	}()
+	return run(ctx)
+}
+
+// stop keeps new runs from starting and waits for running tasks. Tasks
+// still going when ctx is done are cancelled, and stop waits for them to return.
+func (s *scheduler) stop(ctx context.Context) error {
+	s.mu.Lock()
+	started := s.started
+	s.stopping = true
+	s.mu.Unlock()
+	if !started {
+		return nil
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(finished)
+	}()
+
+	var err error
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		err = fmt.Errorf("scheduled tasks still running: %w", ctx.Err())
+	}
+	s.cancel()
+	<-s.done
+	<-finished
+	return err
+}
+
+// taskStatus is the JSON view of a task at /admin/schedules.
+type taskStatus struct {
+	Name     string     `json:"name"`
+	Schedule string     `json:"schedule"`
+	Timeout  string     `json:"timeout"`
+	Running  bool       `json:"running"`
+	NextRun  *time.Time `json:"next_run,omitempty"` // absent once stopping
+	LastRun  *taskRun   `json:"last_run,omitempty"`
+	Runs     int        `json:"runs"`
+	Overlaps int        `json:"skipped_overlaps"`
+}
+
+func (s *scheduler) status() []taskStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]taskStatus, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		st := taskStatus{
+			Name:     task.name,
+			Schedule: task.spec,
+			Timeout:  task.timeout.String(),
+			Running:  task.running,
+			Runs:     task.runs,
+			Overlaps: task.overlaps,
+		}
+		if !s.stopping {
+			next := task.nextRun
+			st.NextRun = &next
+		}
+		if task.lastRun != nil {
+			last := *task.lastRun
+			st.LastRun = &last
+		}
+		result = append(result, st)
+	}
+	return result
+}
+
+func (s *scheduler) handleStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	respondJSON(w, http.StatusOK, s.status())
+}
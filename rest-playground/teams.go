@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// Team groups users together.
+// Membership isn't stored on the Team itself: the store keeps it in a separate
+// set so a user can belong to any number of teams.
+type Team struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+var errTeamNotFound = errors.New("team not found")
+
+// addTeam inserts a new team with a generated ID.
+// It fails with errQuotaExceeded once the store holds quota.MaxTeams teams.
+func (s *userStore) addTeam(name string) (Team, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.quota.MaxTeams > 0 && len(s.teams) >= s.quota.MaxTeams {
+		return Team{}, errQuotaExceeded
+	}
+
+	t := Team{
+		ID:   s.nextTeamID,
+		Name: name,
+	}
+	s.nextTeamID++
+	s.teams = append(s.teams, t)
+	s.members[t.ID] = make(map[int]struct{})
+	s.touch()
+	return t, nil
+}
+
+// listTeams returns a copy of all teams.
+func (s *userStore) listTeams() []Team {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]Team, len(s.teams))
+	copy(result, s.teams)
+	return result
+}
+
+// getTeam looks up a single team by ID.
+func (s *userStore) getTeam(id int) (Team, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.teamIndex(id)
+	if i < 0 {
+		return Team{}, errTeamNotFound
+	}
+	return s.teams[i], nil
+}
+
+// renameTeam changes the name of an existing team.
+func (s *userStore) renameTeam(id int, name string) (Team, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.teamIndex(id)
+	if i < 0 {
+		return Team{}, errTeamNotFound
+	}
+	s.teams[i].Name = name
+	s.touch()
+	return s.teams[i], nil
+}
+
+// deleteTeam removes a team together with its memberships.
+func (s *userStore) deleteTeam(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.teamIndex(id)
+	if i < 0 {
+		return errTeamNotFound
+	}
+	s.teams = append(s.teams[:i], s.teams[i+1:]...)
+	delete(s.members, id)
+	s.touch()
+	return nil
+}
+
+// addMember puts a user into a team. Adding an existing member is a no-op.
+// Both the team and the user must exist.
+func (s *userStore) addMember(teamID, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.teamIndex(teamID) < 0 {
+		return errTeamNotFound
+	}
+	if s.userIndex(userID) < 0 {
+		return errUserNotFound
+	}
+	s.members[teamID][userID] = struct{}{}
+	s.touch()
+	return nil
+}
+
+// removeMember takes a user out of a team. Removing a non-member is a no-op.
+func (s *userStore) removeMember(teamID, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.teamIndex(teamID) < 0 {
+		return errTeamNotFound
+	}
+	delete(s.members[teamID], userID)
+	s.touch()
+	return nil
+}
+
+// teamMembers returns the users in a team, in user creation order.
+func (s *userStore) teamMembers(teamID int) ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.teamIndex(teamID) < 0 {
+		return nil, errTeamNotFound
+	}
+
+	set := s.members[teamID]
+	result := make([]User, 0, len(set))
+	for _, u := range s.users {
+		if _, ok := set[u.ID]; ok {
+			result = append(result, u)
+		}
+	}
+	return result, nil
+}
+
+// userTeams returns the teams a user belongs to, in team creation order.
+func (s *userStore) userTeams(userID int) ([]Team, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.userIndex(userID) < 0 {
+		return nil, errUserNotFound
+	}
+
+	result := make([]Team, 0)
+	for _, t := range s.teams {
+		if _, ok := s.members[t.ID][userID]; ok {
+			result = append(result, t)
+		}
+	}
+	return result, nil
+}
+
+// teamIndex returns the position of the team in s.teams, or -1.
+// Callers must hold s.mu.
+func (s *userStore) teamIndex(id int) int {
+	for i, t := range s.teams {
+		if t.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// registerTeamRoutes wires up the team endpoints. Each request works on its
+// own tenant's store, resolved by tenantMiddleware.
+func registerTeamRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /teams", func(w http.ResponseWriter, r *http.Request) {
+		handleCreateTeam(w, r, storeFor(r))
+	})
+	mux.HandleFunc("GET /teams", cacheListMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		respondJSON(w, http.StatusOK, storeFor(r).listTeams())
+	}))
+	mux.HandleFunc("GET /teams/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handleGetTeam(w, r, storeFor(r))
+	})
+	mux.HandleFunc("PUT /teams/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handleRenameTeam(w, r, storeFor(r))
+	})
+	mux.HandleFunc("DELETE /teams/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handleDeleteTeam(w, r, storeFor(r))
+	})
+
+	mux.HandleFunc("GET /teams/{id}/members", cacheListMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleListMembers(w, r, storeFor(r))
+	}))
+	mux.HandleFunc("PUT /teams/{id}/members/{userID}", func(w http.ResponseWriter, r *http.Request) {
+		handleAddMember(w, r, storeFor(r))
+	})
+	mux.HandleFunc("DELETE /teams/{id}/members/{userID}", func(w http.ResponseWriter, r *http.Request) {
+		handleRemoveMember(w, r, storeFor(r))
+	})
+	mux.HandleFunc("GET /users/{id}/teams", cacheListMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		handleUserTeams(w, r, storeFor(r))
+	}))
+}
+
+type teamRequest struct {
+	Name string `json:"name"`
+}
+
+// decodeTeamRequest reads and validates a create/rename body.
+func decodeTeamRequest(w http.ResponseWriter, r *http.Request) (teamRequest, bool) {
+	var req teamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return req, false
+	}
+	if req.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+func handleCreateTeam(w http.ResponseWriter, r *http.Request, store *userStore) {
+	req, ok := decodeTeamRequest(w, r)
+	if !ok {
+		return
+	}
+
+	t, err := store.addTeam(req.Name)
+	if err != nil {
+		respondStoreError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusCreated, t)
+}
+
+func handleGetTeam(w http.ResponseWriter, r *http.Request, store *userStore) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	t, err := store.getTeam(id)
+	if err != nil {
+		respondStoreError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, t)
+}
+
+func handleRenameTeam(w http.ResponseWriter, r *http.Request, store *userStore) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	req, ok := decodeTeamRequest(w, r)
+	if !ok {
+		return
+	}
+
+	t, err := store.renameTeam(id, req.Name)
+	if err != nil {
+		respondStoreError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, t)
+}
+
+func handleDeleteTeam(w http.ResponseWriter, r *http.Request, store *userStore) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	if err := store.deleteTeam(id); err != nil {
+		respondStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleListMembers(w http.ResponseWriter, r *http.Request, store *userStore) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	users, err := store.teamMembers(id)
+	if err != nil {
+		respondStoreError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, users)
+}
+
+func handleAddMember(w http.ResponseWriter, r *http.Request, store *userStore) {
+	teamID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	userID, ok := pathID(w, r, "userID")
+	if !ok {
+		return
+	}
+
+	if err := store.addMember(teamID, userID); err != nil {
+		respondStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleRemoveMember(w http.ResponseWriter, r *http.Request, store *userStore) {
+	teamID, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+	userID, ok := pathID(w, r, "userID")
+	if !ok {
+		return
+	}
+
+	if err := store.removeMember(teamID, userID); err != nil {
+		respondStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func handleUserTeams(w http.ResponseWriter, r *http.Request, store *userStore) {
+	id, ok := pathID(w, r, "id")
+	if !ok {
+		return
+	}
+
+	teams, err := store.userTeams(id)
+	if err != nil {
+		respondStoreError(w, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, teams)
+}
+
+// respondStoreError maps the store's sentinel errors to HTTP responses.
+func respondStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUserNotFound), errors.Is(err, errTeamNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, errQuotaExceeded):
+		http.Error(w, err.Error(), http.StatusForbidden)
+	default:
+		http.Error(w, "internal error", http.StatusInternalServerError)
+	}
+}
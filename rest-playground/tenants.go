@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	tenantHeader    = "X-Tenant-ID"
+	defaultTenantID = "default"
+	// tenantParam names the tenant in links that are opened in a browser,
+	// such as the ones in verification emails, which can't send headers.
+	tenantParam = "tenant"
+)
+
+var (
+	errQuotaExceeded   = errors.New("tenant quota exceeded")
+	errUnknownTenant   = errors.New("unknown tenant")
+	errInvalidTenantID = errors.New("invalid tenant ID")
+	errTenantLimit     = errors.New("too many tenants")
+)
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// tenantQuota limits how much one tenant may store. Zero means unlimited.
+type tenantQuota struct {
+	MaxUsers int `json:"max_users" yaml:"max_users"`
+	MaxTeams int `json:"max_teams" yaml:"max_teams"`
+}
+
+// tenant is one customer's slice of the server. Everything that holds user
+// data lives here, so a handler working on one tenant has no way to reach
+// another tenant's users, teams, credentials or sessions.
+type tenant struct {
+	id    string
+	store *userStore
+	auth  *authService
+}
+
+// tenantRegistry creates and hands out tenants.
+type tenantRegistry struct {
+	mailer    Mailer
+	publicURL string
+
+	mu           sync.Mutex
+	tenants      map[string]*tenant
+	defaultQuota tenantQuota
+	quotas       map[string]tenantQuota // per-tenant overrides of defaultQuota
+	// allowed, when non-empty, is the closed list of tenants. Otherwise a
+	// tenant springs into existence the first time a request names it, up
+	// to maxTenants of them, so anonymous callers can't make us allocate a
+	// store per made-up tenant ID forever.
+	allowed    map[string]bool
+	maxTenants int
+
+	// userDeleted are called with every user deleted in any tenant.
+	userDeleted []func(User)
+}
+
+func newTenantRegistry(mailer Mailer, publicURL string, allowed []string, maxTenants int, defaultQuota tenantQuota, quotas map[string]tenantQuota) *tenantRegistry {
+	reg := &tenantRegistry{
+		mailer:       mailer,
+		publicURL:    publicURL,
+		tenants:      make(map[string]*tenant),
+		defaultQuota: defaultQuota,
+		quotas:       quotas,
+		allowed:      make(map[string]bool),
+		maxTenants:   maxTenants,
+	}
+	for _, id := range allowed {
+		reg.allowed[id] = true
+	}
+	return reg
+}
+
+// get returns the tenant, creating it on first use. The default tenant
+// doesn't count against maxTenants, so it is always there for requests that
+// name no tenant.
+func (reg *tenantRegistry) get(id string) (*tenant, error) {
+	if !tenantIDPattern.MatchString(id) {
+		return nil, errInvalidTenantID
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if t, ok := reg.tenants[id]; ok {
+		return t, nil
+	}
+	if len(reg.allowed) > 0 && !reg.allowed[id] {
+		return nil, errUnknownTenant
+	}
+	if len(reg.allowed) == 0 && id != defaultTenantID && reg.autoCreated() >= reg.maxTenants {
+		return nil, errTenantLimit
+	}
+
+	store := newUserStore()
+	store.setQuota(reg.quotaFor(id))
+	store.onDelete = reg.notifyUserDeleted
+	t := &tenant{
+		id:    id,
+		store: store,
+		auth:  newAuthService(id, store, reg.mailer, reg.publicURL),
+	}
+	reg.tenants[id] = t
+	return t, nil
+}
+
+// onUserDeleted registers fn to be called with every user deleted from now
+// on, in any tenant.
+func (reg *tenantRegistry) onUserDeleted(fn func(User)) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.userDeleted = append(reg.userDeleted, fn)
+}
+
+func (reg *tenantRegistry) notifyUserDeleted(u User) {
+	reg.mu.Lock()
+	fns := reg.userDeleted
+	reg.mu.Unlock()
+	for _, fn := range fns {
+		fn(u)
+	}
+}
+
+// autoCreated counts the tenants other than the default one. Callers must
+// hold reg.mu.
+func (reg *tenantRegistry) autoCreated() int {
+	n := len(reg.tenants)
+	if _, ok := reg.tenants[defaultTenantID]; ok {
+		n--
+	}
+	return n
+}
+
+// all returns every tenant created so far, sorted by ID.
+func (reg *tenantRegistry) all() []*tenant {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	result := make([]*tenant, 0, len(reg.tenants))
+	for _, t := range reg.tenants {
+		result = append(result, t)
+	}
+	slices.SortFunc(result, func(a, b *tenant) int { return strings.Compare(a.id, b.id) })
+	return result
+}
+
+// setQuotas replaces the quotas and applies them to existing tenants too.
+func (reg *tenantRegistry) setQuotas(defaultQuota tenantQuota, quotas map[string]tenantQuota) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	reg.defaultQuota = defaultQuota
+	reg.quotas = quotas
+	for id, t := range reg.tenants {
+		t.store.setQuota(reg.quotaFor(id))
+	}
+}
+
+// quotaFor returns the quota that applies to a tenant. Callers must hold reg.mu.
+func (reg *tenantRegistry) quotaFor(id string) tenantQuota {
+	if q, ok := reg.quotas[id]; ok {
+		return q
+	}
+	return reg.defaultQuota
+}
+
+// parseTenantQuotas parses "acme=1000:50,beta=10:5" (tenant=maxUsers:maxTeams).
+func parseTenantQuotas(s string) (map[string]tenantQuota, error) {
+	quotas := make(map[string]tenantQuota)
+	for _, entry := range splitList(s) {
+		id, limits, ok := strings.Cut(entry, "=")
+		users, teams, ok2 := strings.Cut(limits, ":")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("quota %q: want tenant=maxUsers:maxTeams", entry)
+		}
+		var q tenantQuota
+		var err error
+		if q.MaxUsers, err = strconv.Atoi(users); err != nil {
+			return nil, fmt.Errorf("quota %q: %w", entry, err)
+		}
+		if q.MaxTeams, err = strconv.Atoi(teams); err != nil {
+			return nil, fmt.Errorf("quota %q: %w", entry, err)
+		}
+		quotas[id] = q
+	}
+	return quotas, nil
+}
+
+// sessionTenantClaim returns the tenant a session token was issued for.
+// Session tokens look like "<tenant>.<random>"; see authService.login.
+func sessionTenantClaim(r *http.Request) string {
+	token, ok := sessionToken(r)
+	if !ok {
+		return ""
+	}
+	id, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return ""
+	}
+	return id
+}
+
+// tenantMiddleware resolves which tenant a request belongs to and puts it in
+// the context. A session token's tenant claim wins; an X-Tenant-ID header or
+// ?tenant= parameter that contradicts it is rejected rather than silently
+// ignored. Requests without any of them go to the default tenant.
+func tenantMiddleware(reg *tenantRegistry, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Responses differ per tenant, so caches must keep them apart.
+		w.Header().Add("Vary", tenantHeader)
+		w.Header().Add("Vary", "Cookie")
+
+		id := r.Header.Get(tenantHeader)
+		if id == "" {
+			id = r.URL.Query().Get(tenantParam)
+		}
+		if claim := sessionTenantClaim(r); claim != "" {
+			if id != "" && id != claim {
+				http.Error(w, "tenant does not match session", http.StatusForbidden)
+				return
+			}
+			id = claim
+		}
+		if id == "" {
+			id = defaultTenantID
+		}
+
+		t, err := reg.get(id)
+		switch {
+		case errors.Is(err, errInvalidTenantID):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), tenantKey, t)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// tenantFrom returns the tenant stored by tenantMiddleware.
+func tenantFrom(ctx context.Context) *tenant {
+	t, _ := ctx.Value(tenantKey).(*tenant)
+	return t
+}
+
+// storeFor is a shortcut for the request tenant's user store.
+func storeFor(r *http.Request) *userStore {
+	return tenantFrom(r.Context()).store
+}
+
+// authFor is a shortcut for the request tenant's auth service.
+func authFor(r *http.Request) *authService {
+	return tenantFrom(r.Context()).auth
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// discardMailer drops every message; registration sends a verification email.
+type discardMailer struct{}
+
+func (discardMailer) Send(context.Context, Message) error { return nil }
+
+// newTenantTestServer serves the users, auth and verification routes behind
+// tenantMiddleware, the way main wires them up.
+func newTenantTestServer(reg *tenantRegistry) http.Handler {
+	mux := http.NewServeMux()
+	registerUserRoutes(mux)
+	registerAuthRoutes(mux)
+	registerVerificationRoutes(mux)
+	return tenantMiddleware(reg, mux)
+}
+
+// tenantRequest sends one request; tenant and token may be empty.
+func tenantRequest(t *testing.T, h http.Handler, method, target, tenant, token, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	if tenant != "" {
+		r.Header.Set(tenantHeader, tenant)
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func createTenantUser(t *testing.T, h http.Handler, tenant, name string) User {
+	t.Helper()
+	w := tenantRequest(t, h, "POST", "/users", tenant, "", `{"name": "`+name+`"}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create %s in %s: status %d: %s", name, tenant, w.Code, w.Body)
+	}
+	var u User
+	if err := json.Unmarshal(w.Body.Bytes(), &u); err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestTenantUsersAreInvisibleToOtherTenants(t *testing.T) {
+	h := newTenantTestServer(newTenantRegistry(discardMailer{}, "", nil, 10, tenantQuota{}, nil))
+	u := createTenantUser(t, h, "acme", "Ana")
+	path := "/users/" + strconv.Itoa(u.ID)
+
+	for _, tc := range []struct{ method, body string }{
+		{"GET", ""},
+		{"PUT", `{"name": "Mallory"}`},
+		{"DELETE", ""},
+	} {
+		if w := tenantRequest(t, h, tc.method, path, "beta", "", tc.body); w.Code != http.StatusNotFound {
+			t.Errorf("%s %s as beta: status %d, want 404", tc.method, path, w.Code)
+		}
+	}
+
+	w := tenantRequest(t, h, "GET", "/users", "beta", "", "")
+	if strings.TrimSpace(w.Body.String()) != "[]" {
+		t.Errorf("beta lists %s, want no users", w.Body)
+	}
+
+	// Nothing beta tried got through to acme's user.
+	w = tenantRequest(t, h, "GET", path, "acme", "", "")
+	var got User
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil || got.Name != "Ana" {
+		t.Errorf("acme's user after beta's requests: status %d, %s", w.Code, w.Body)
+	}
+}
+
+func TestTenantsHaveTheirOwnIDSequence(t *testing.T) {
+	h := newTenantTestServer(newTenantRegistry(discardMailer{}, "", nil, 10, tenantQuota{}, nil))
+
+	createTenantUser(t, h, "acme", "Ana")
+	if u := createTenantUser(t, h, "acme", "Bob"); u.ID != 2 {
+		t.Errorf("second acme user has ID %d, want 2", u.ID)
+	}
+	if u := createTenantUser(t, h, "beta", "Cid"); u.ID != 1 {
+		t.Errorf("first beta user has ID %d, want 1", u.ID)
+	}
+}
+
+func TestTenantsHaveTheirOwnQuota(t *testing.T) {
+	quotas := map[string]tenantQuota{"acme": {MaxUsers: 1}}
+	h := newTenantTestServer(newTenantRegistry(discardMailer{}, "", nil, 10, tenantQuota{MaxUsers: 2}, quotas))
+
+	createTenantUser(t, h, "acme", "Ana")
+	if w := tenantRequest(t, h, "POST", "/users", "acme", "", `{"name": "Bob"}`); w.Code != http.StatusForbidden {
+		t.Errorf("acme over its quota: status %d, want 403", w.Code)
+	}
+
+	// beta gets the default quota of 2, untouched by acme's users.
+	createTenantUser(t, h, "beta", "Cid")
+	createTenantUser(t, h, "beta", "Dan")
+	if w := tenantRequest(t, h, "POST", "/users", "beta", "", `{"name": "Eve"}`); w.Code != http.StatusForbidden {
+		t.Errorf("beta over its quota: status %d, want 403", w.Code)
+	}
+}
+
+func TestTenantMustMatchSession(t *testing.T) {
+	h := newTenantTestServer(newTenantRegistry(discardMailer{}, "", nil, 10, tenantQuota{}, nil))
+
+	creds := `{"name": "Ana", "email": "ana@example.com", "password": "correct-horse"}`
+	if w := tenantRequest(t, h, "POST", "/auth/register", "acme", "", creds); w.Code != http.StatusCreated {
+		t.Fatalf("register: status %d: %s", w.Code, w.Body)
+	}
+	w := tenantRequest(t, h, "POST", "/auth/login", "acme", "", creds)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: status %d: %s", w.Code, w.Body)
+	}
+	var token string
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookieName {
+			token = c.Value
+		}
+	}
+
+	tests := []struct {
+		name, target, tenant string
+		want                 int
+	}{
+		{"no tenant", "/auth/me", "", http.StatusOK},
+		{"same tenant", "/auth/me", "acme", http.StatusOK},
+		{"other tenant header", "/auth/me", "beta", http.StatusForbidden},
+		{"other tenant parameter", "/auth/me?tenant=beta", "", http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if w := tenantRequest(t, h, "GET", tc.target, tc.tenant, token, ""); w.Code != tc.want {
+				t.Errorf("status %d, want %d: %s", w.Code, tc.want, w.Body)
+			}
+		})
+	}
+}
+
+// Emailed links carry ?tenant=, because a browser following them can't set
+// X-Tenant-ID.
+func TestTenantFromQueryParameter(t *testing.T) {
+	h := newTenantTestServer(newTenantRegistry(discardMailer{}, "", nil, 10, tenantQuota{}, nil))
+	u := createTenantUser(t, h, "acme", "Ana")
+
+	if w := tenantRequest(t, h, "GET", "/users/"+strconv.Itoa(u.ID)+"?tenant=acme", "", "", ""); w.Code != http.StatusOK {
+		t.Errorf("?tenant=acme: status %d, want 200", w.Code)
+	}
+	if w := tenantRequest(t, h, "GET", "/users/"+strconv.Itoa(u.ID), "", "", ""); w.Code != http.StatusNotFound {
+		t.Errorf("default tenant: status %d, want 404", w.Code)
+	}
+}
+
+func TestTenantLimit(t *testing.T) {
+	reg := newTenantRegistry(discardMailer{}, "", nil, 1, tenantQuota{}, nil)
+	if _, err := reg.get("acme"); err != nil {
+		t.Fatalf("first tenant: %v", err)
+	}
+	if _, err := reg.get("beta"); err != errTenantLimit {
+		t.Errorf("second tenant: %v, want %v", err, errTenantLimit)
+	}
+	if _, err := reg.get(defaultTenantID); err != nil {
+		t.Errorf("default tenant: %v", err)
+	}
+	if _, err := reg.get("acme"); err != nil {
+		t.Errorf("existing tenant: %v", err)
+	}
+
+	closed := newTenantRegistry(discardMailer{}, "", []string{"acme"}, 0, tenantQuota{}, nil)
+	if _, err := closed.get("beta"); err != errUnknownTenant {
+		t.Errorf("tenant outside -tenants: %v, want %v", err, errUnknownTenant)
+	}
+}
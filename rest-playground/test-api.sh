@@ -2,9 +2,110 @@
 
 curl -v http://localhost:8080/health
 
+curl -v http://localhost:8080/livez
+
+# 503 with the failing checks listed when a dependency is down or during shutdown.
+curl -v http://localhost:8080/readyz
+
 curl -v \
   -X POST http://localhost:8080/users \
   -H "Content-Type: application/json" \
   -d '{"name": "Cristi"}'
 
 curl -v http://localhost:8080/users/1
+
+curl -v \
+  -X POST http://localhost:8080/teams \
+  -H "Content-Type: application/json" \
+  -d '{"name": "Platform"}'
+
+curl -v -X PUT http://localhost:8080/teams/1/members/1
+
+curl -v http://localhost:8080/teams/1/members
+
+curl -v http://localhost:8080/users/1/teams
+
+curl -v \
+  -X POST http://localhost:8080/auth/register \
+  -H "Content-Type: application/json" \
+  -d '{"name": "Cristi", "email": "cristi@example.com", "password": "correct-horse"}'
+
+curl -v -c cookies.txt \
+  -X POST http://localhost:8080/auth/login \
+  -H "Content-Type: application/json" \
+  -d '{"email": "cristi@example.com", "password": "correct-horse"}'
+
+curl -v -b cookies.txt http://localhost:8080/auth/me
+
+# Always 202, whether or not the account exists. The link shows up in the server log
+# (or in -mail-dir) unless -smtp-addr is set.
+curl -v \
+  -X POST http://localhost:8080/auth/password/forgot \
+  -H "Content-Type: application/json" \
+  -d '{"email": "cristi@example.com"}'
+
+# Only the signed-in user can change their own avatar; Cristi registered as user 2.
+curl -v -b cookies.txt -X PUT http://localhost:8080/users/2/avatar -F "avatar=@avatar.png"
+
+curl -v -o thumbnail.png http://localhost:8080/users/2/avatar/thumbnail
+
+# Admin UI: start the server with -admin-emails cristi@example.com, verify the
+# address through the emailed link and open http://localhost:8080/admin in a
+# browser. Admins of other tenants are listed as -admin-emails acme:ana@example.com.
+
+curl -v \
+  -X POST http://localhost:8080/jobs \
+  -H "Content-Type: application/json" \
+  -d '{"type": "export_users", "params": {"format": "csv"}}'
+
+# HTTPS with client certificates:
+#   go run . gen-certs -out certs
+#   go run . -tls-cert certs/server.pem -tls-key certs/server-key.pem -tls-client-ca certs/ca.pem
+curl -v --cacert certs/ca.pem \
+  --cert certs/client.pem --key certs/client-key.pem \
+  https://localhost:8080/tls/client
+
+# Tenants: every tenant has its own users, teams and sessions.
+# Without X-Tenant-ID requests go to the "default" tenant.
+curl -v \
+  -X POST http://localhost:8080/users \
+  -H "Content-Type: application/json" \
+  -H "X-Tenant-ID: acme" \
+  -d '{"name": "Acme Admin"}'
+
+curl -v -H "X-Tenant-ID: acme" http://localhost:8080/users
+
+# The same calls through the Go client, e.g.:
+#   go run ./usersctl create Cristi
+#   go run ./usersctl -o csv list -all
+#   go run ./usersctl export -format csv -out users.csv
+curl -v "http://localhost:8080/users?q=cri&limit=10&offset=0"
+
+# Record traffic on one instance and replay it against another:
+#   go run . -record-file session.ndjson
+#   go run . replay -target http://localhost:8081 -ignore 'id,[*].id' session.ndjson
+# Passwords and tokens are redacted in bodies and URLs, so recorded logins
+# won't replay; record with -record-redact-fields "" on a test instance if
+# they must, and skip whole subtrees with e.g. -record-skip-paths /auth/.
+
+# Reloadable settings (CORS, rate limit, quotas, features, mail, admins):
+#   cp config.example.yaml config.yaml && go run . -config config.yaml
+# Edits to config.yaml apply within a few seconds; an invalid edit is logged
+# and the previous config stays. The effective config, secrets redacted,
+# needs an admin session:
+curl -v -b cookies.txt http://localhost:8080/admin/config
+
+# GraphQL: users with their teams in one round trip, paged with cursors.
+curl -v \
+  -X POST http://localhost:8080/graphql \
+  -H "Content-Type: application/json" \
+  -d '{"query": "{ users(first: 10, filter: {query: \"cri\"}) { totalCount edges { node { id name teams { name } } } pageInfo { hasNextPage endCursor } } }"}'
+
+curl -v \
+  -X POST http://localhost:8080/graphql \
+  -H "Content-Type: application/json" \
+  -d '{"query": "mutation($name: String!) { createUser(name: $name) { id name } }", "variables": {"name": "Cristi"}}'
+
+# Scheduled maintenance (see -purge-schedule and -job-prune-schedule): last
+# and next run of every task, for admins.
+curl -v -b cookies.txt http://localhost:8080/admin/schedules
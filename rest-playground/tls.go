@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// clientIdentity is who a verified client certificate says the caller is.
+type clientIdentity struct {
+	CommonName   string   `json:"common_name"`
+	Organization []string `json:"organization,omitempty"`
+	Serial       string   `json:"serial"`
+}
+
+// newTLSConfig builds the server TLS config. With clientCA set, clients must
+// present a certificate signed by it (mutual TLS), unless optional is true, in
+// which case a certificate is verified if sent but not required.
+func newTLSConfig(clientCA string, optional bool) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCA == "" {
+		return cfg, nil
+	}
+
+	pemData, err := os.ReadFile(clientCA)
+	if err != nil {
+		return nil, fmt.Errorf("read client CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, errors.New("client CA file contains no certificates")
+	}
+
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	if optional {
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
+
+// clientIdentityMiddleware puts the verified client certificate's identity
+// into the request context. Plain HTTP and TLS requests without a client
+// certificate pass through untouched.
+func clientIdentityMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// VerifiedChains is only filled in when the certificate checked out
+		// against ClientCAs; PeerCertificates alone could be self-signed.
+		if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		leaf := r.TLS.VerifiedChains[0][0]
+		id := clientIdentity{
+			CommonName:   leaf.Subject.CommonName,
+			Organization: leaf.Subject.Organization,
+			Serial:       leaf.SerialNumber.Text(16),
+		}
+		ctx := context.WithValue(r.Context(), clientIdentityKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// clientIdentityFrom returns the identity stored by clientIdentityMiddleware.
+func clientIdentityFrom(ctx context.Context) (clientIdentity, bool) {
+	id, ok := ctx.Value(clientIdentityKey).(clientIdentity)
+	return id, ok
+}
+
+func handleClientIdentity(w http.ResponseWriter, r *http.Request) {
+	id, ok := clientIdentityFrom(r.Context())
+	if !ok {
+		http.Error(w, "no verified client certificate", http.StatusNotFound)
+		return
+	}
+	respondJSON(w, http.StatusOK, id)
+}
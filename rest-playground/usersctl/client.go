@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// User mirrors the server's User JSON.
+type User struct {
+	ID            int    `json:"id"`
+	Name          string `json:"name"`
+	Email         string `json:"email,omitempty"`
+	EmailVerified bool   `json:"email_verified,omitempty"`
+}
+
+// Job mirrors the server's Job JSON.
+type Job struct {
+	ID        string `json:"id"`
+	Type      string `json:"type"`
+	Status    string `json:"status"`
+	Done      int    `json:"done"`
+	Total     int    `json:"total"`
+	Error     string `json:"error,omitempty"`
+	ResultURL string `json:"result_url,omitempty"`
+}
+
+func (j Job) finished() bool {
+	return j.Status == "succeeded" || j.Status == "failed" || j.Status == "cancelled"
+}
+
+// apiError is a non-2xx answer from the server.
+type apiError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// client talks to the rest-playground HTTP API.
+type client struct {
+	baseURL string
+	token   string
+	tenant  string
+	http    *http.Client
+}
+
+// do sends a request and decodes a JSON response into out, if out is non-nil.
+// The response is returned with its body already closed, for the headers.
+func (c *client) do(ctx context.Context, method, path string, body, out any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	resp, err := c.send(ctx, method, path, reader)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp, fmt.Errorf("decode response: %w", err)
+		}
+	}
+	return resp, nil
+}
+
+// send sends a request and turns non-2xx answers into *apiError. On success
+// the caller owns resp.Body.
+func (c *client) send(ctx context.Context, method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if c.tenant != "" {
+		req.Header.Set("X-Tenant-ID", c.tenant)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+
+	defer resp.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+	return nil, &apiError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+}
+
+func (c *client) createUser(ctx context.Context, name string) (User, error) {
+	var u User
+	_, err := c.do(ctx, http.MethodPost, "/users", map[string]string{"name": name}, &u)
+	return u, err
+}
+
+func (c *client) getUser(ctx context.Context, id int) (User, error) {
+	var u User
+	_, err := c.do(ctx, http.MethodGet, "/users/"+strconv.Itoa(id), nil, &u)
+	return u, err
+}
+
+// listUsers fetches one page and the total number of matching users.
+func (c *client) listUsers(ctx context.Context, query string, offset, limit int) ([]User, int, error) {
+	params := url.Values{}
+	if query != "" {
+		params.Set("q", query)
+	}
+	params.Set("offset", strconv.Itoa(offset))
+	params.Set("limit", strconv.Itoa(limit))
+
+	var users []User
+	resp, err := c.do(ctx, http.MethodGet, "/users?"+params.Encode(), nil, &users)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := strconv.Atoi(resp.Header.Get("X-Total-Count"))
+	if err != nil {
+		// An older server without paging: everything came back at once.
+		total = len(users)
+	}
+	return users, total, nil
+}
+
+func (c *client) updateUser(ctx context.Context, id int, name string) (User, error) {
+	var u User
+	_, err := c.do(ctx, http.MethodPut, "/users/"+strconv.Itoa(id), map[string]string{"name": name}, &u)
+	return u, err
+}
+
+func (c *client) deleteUser(ctx context.Context, id int) error {
+	_, err := c.do(ctx, http.MethodDelete, "/users/"+strconv.Itoa(id), nil, nil)
+	return err
+}
+
+// login returns the session token the server put into its session cookie.
+func (c *client) login(ctx context.Context, email, password string) (string, error) {
+	resp, err := c.do(ctx, http.MethodPost, "/auth/login",
+		map[string]string{"email": email, "password": password}, nil)
+	if err != nil {
+		return "", err
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "session" {
+			return cookie.Value, nil
+		}
+	}
+	return "", errors.New("server did not return a session")
+}
+
+func (c *client) submitJob(ctx context.Context, jobType string, params any) (Job, error) {
+	var job Job
+	_, err := c.do(ctx, http.MethodPost, "/jobs", map[string]any{"type": jobType, "params": params}, &job)
+	return job, err
+}
+
+func (c *client) getJob(ctx context.Context, id string) (Job, error) {
+	var job Job
+	_, err := c.do(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), nil, &job)
+	return job, err
+}
+
+// jobResult opens the result of a finished job; the caller closes it.
+func (c *client) jobResult(ctx context.Context, job Job) (io.ReadCloser, error) {
+	resp, err := c.send(ctx, http.MethodGet, job.ResultURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
@@ -0,0 +1,471 @@
+// Command usersctl is a command-line client for the rest-playground API.
+//
+//	usersctl [global flags] <command> [command flags] [args]
+//
+// Run "usersctl -h" for the list of commands.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exit codes, so scripts can tell failures apart without parsing output.
+const (
+	exitOK        = 0
+	exitError     = 1 // anything not covered below, e.g. an unreadable input file
+	exitUsage     = 2 // bad flags or arguments
+	exitNetwork   = 3 // the server couldn't be reached or didn't answer in time
+	exitClient    = 4 // a 4xx not covered below, e.g. 400 validation errors
+	exitAuth      = 5 // 401 or 403
+	exitNotFound  = 6 // 404
+	exitConflict  = 7 // 409
+	exitServer    = 8 // 5xx
+	exitJobFailed = 9 // an import or export job ended as failed or cancelled
+)
+
+var errUsage = errors.New("usage")
+
+// errJobFailed reports a background job that didn't succeed.
+var errJobFailed = errors.New("job did not succeed")
+
+const usage = `usage: usersctl [global flags] <command> [command flags] [args]
+
+commands:
+  create <name>            create a user
+  get <id>                 show a user
+  list [-q text] [-limit n] [-offset n] [-all]
+                           list users, one page at a time
+  update <id> <name>       rename a user
+  delete <id>              delete a user
+  import [-wait] <file>    import users from a JSON, NDJSON or CSV file ("-" for stdin)
+  export [-format json|csv] [-out file]
+                           export all users through a background job
+  login <email> <password> print a session token for -token
+
+global flags:
+`
+
+// app holds what every command needs.
+type app struct {
+	client *client
+	format string
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("usersctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	baseURL := fs.String("url", envOr("USERSCTL_URL", "http://localhost:8080"), "API base URL ($USERSCTL_URL)")
+	token := fs.String("token", os.Getenv("USERSCTL_TOKEN"), "session token sent as a Bearer token ($USERSCTL_TOKEN)")
+	tenant := fs.String("tenant", os.Getenv("USERSCTL_TENANT"), "tenant ID sent as X-Tenant-ID ($USERSCTL_TENANT)")
+	format := fs.String("o", "table", "output format: table, json or csv")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout for each HTTP request")
+	fs.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if !slices.Contains([]string{"table", "json", "csv"}, *format) || fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+
+	a := &app{
+		client: &client{
+			baseURL: strings.TrimSuffix(*baseURL, "/"),
+			token:   *token,
+			tenant:  *tenant,
+			http:    &http.Client{Timeout: *timeout},
+		},
+		format: *format,
+		stdout: stdout,
+		stderr: stderr,
+	}
+
+	commands := map[string]func(context.Context, []string) error{
+		"create": a.create,
+		"get":    a.get,
+		"list":   a.list,
+		"update": a.update,
+		"delete": a.delete,
+		"import": a.importUsers,
+		"export": a.exportUsers,
+		"login":  a.login,
+	}
+	cmd, ok := commands[fs.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "usersctl: unknown command %q\n", fs.Arg(0))
+		fs.Usage()
+		return exitUsage
+	}
+
+	// A bare errUsage comes after the usage line was printed; anything
+	// wrapping it, like a bad ID, still needs its message.
+	err := cmd(ctx, fs.Args()[1:])
+	if err != nil && err != errUsage {
+		fmt.Fprintf(stderr, "usersctl %s: %v\n", fs.Arg(0), err)
+	}
+	return exitCode(err)
+}
+
+// exitCode maps an error to one of the exit codes above.
+func exitCode(err error) int {
+	var apiErr *apiError
+	var urlErr *url.Error
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errUsage):
+		return exitUsage
+	case errors.Is(err, errJobFailed):
+		return exitJobFailed
+	case errors.As(err, &apiErr):
+		switch code := apiErr.StatusCode; {
+		case code == http.StatusUnauthorized, code == http.StatusForbidden:
+			return exitAuth
+		case code == http.StatusNotFound:
+			return exitNotFound
+		case code == http.StatusConflict:
+			return exitConflict
+		case code >= 500:
+			return exitServer
+		default:
+			return exitClient
+		}
+	case errors.As(err, &urlErr):
+		return exitNetwork
+	default:
+		return exitError
+	}
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
+// usageErr prints a command's usage line and returns errUsage.
+func (a *app) usageErr(line string) error {
+	fmt.Fprintln(a.stderr, "usage: usersctl "+line)
+	return errUsage
+}
+
+// parseFlags parses a command's own flags, reporting bad ones as errUsage.
+func (a *app) parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(a.stderr)
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	return nil
+}
+
+func parseID(s string) (int, error) {
+	id, err := strconv.Atoi(s)
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("%w: invalid user ID %q", errUsage, s)
+	}
+	return id, nil
+}
+
+func (a *app) create(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return a.usageErr("create <name>")
+	}
+	u, err := a.client.createUser(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return printUsers(a.stdout, a.format, []User{u})
+}
+
+func (a *app) get(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return a.usageErr("get <id>")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	u, err := a.client.getUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	return printUsers(a.stdout, a.format, []User{u})
+}
+
+func (a *app) list(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	query := fs.String("q", "", "only users whose name or email contains this text")
+	limit := fs.Int("limit", 20, "page size")
+	offset := fs.Int("offset", 0, "number of users to skip")
+	all := fs.Bool("all", false, "fetch every page, not just one")
+	if err := a.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *limit < 1 || *offset < 0 || fs.NArg() > 0 {
+		return a.usageErr("list [-q text] [-limit n] [-offset n] [-all]")
+	}
+
+	users, total, err := a.client.listUsers(ctx, *query, *offset, *limit)
+	if err != nil {
+		return err
+	}
+	for *all && *offset+len(users) < total {
+		page, _, err := a.client.listUsers(ctx, *query, *offset+len(users), *limit)
+		if err != nil {
+			return err
+		}
+		if len(page) == 0 {
+			break // users were deleted while paging
+		}
+		users = append(users, page...)
+	}
+
+	if err := printUsers(a.stdout, a.format, users); err != nil {
+		return err
+	}
+	if a.format == "table" {
+		// On stderr, so the table itself stays clean for piping.
+		first := *offset + 1
+		if len(users) == 0 {
+			first = *offset
+		}
+		fmt.Fprintf(a.stderr, "showing %d-%d of %d\n", first, *offset+len(users), total)
+	}
+	return nil
+}
+
+func (a *app) update(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return a.usageErr("update <id> <name>")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	u, err := a.client.updateUser(ctx, id, args[1])
+	if err != nil {
+		return err
+	}
+	return printUsers(a.stdout, a.format, []User{u})
+}
+
+func (a *app) delete(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return a.usageErr("delete <id>")
+	}
+	id, err := parseID(args[0])
+	if err != nil {
+		return err
+	}
+	return a.client.deleteUser(ctx, id)
+}
+
+func (a *app) login(ctx context.Context, args []string) error {
+	if len(args) != 2 {
+		return a.usageErr("login <email> <password>")
+	}
+	token, err := a.client.login(ctx, args[0], args[1])
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(a.stdout, token)
+	return nil
+}
+
+// importUser is one entry of the import_users job params.
+type importUser struct {
+	Name string `json:"name"`
+}
+
+func (a *app) importUsers(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	wait := fs.Bool("wait", true, "wait for the job to finish")
+	if err := a.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return a.usageErr("import [-wait=false] <file>")
+	}
+
+	users, err := readImportFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	job, err := a.client.submitJob(ctx, "import_users", map[string]any{"users": users})
+	if err != nil {
+		return err
+	}
+	if *wait {
+		if job, err = a.waitForJob(ctx, job); err != nil {
+			return err
+		}
+	}
+	if err := printJob(a.stdout, a.format, job); err != nil {
+		return err
+	}
+	if job.finished() && job.Status != "succeeded" {
+		return fmt.Errorf("%w: %s %s", errJobFailed, job.Status, job.Error)
+	}
+	return nil
+}
+
+func (a *app) exportUsers(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "json", "export format: json (one user per line) or csv")
+	out := fs.String("out", "", "file to write the export to (default stdout)")
+	if err := a.parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || (*format != "json" && *format != "csv") {
+		return a.usageErr("export [-format json|csv] [-out file]")
+	}
+
+	job, err := a.client.submitJob(ctx, "export_users", map[string]string{"format": *format})
+	if err != nil {
+		return err
+	}
+	if job, err = a.waitForJob(ctx, job); err != nil {
+		return err
+	}
+	if job.Status != "succeeded" {
+		return fmt.Errorf("%w: %s %s", errJobFailed, job.Status, job.Error)
+	}
+
+	body, err := a.client.jobResult(ctx, job)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	w := a.stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if _, err := io.Copy(w, body); err != nil {
+		return err
+	}
+	if *out != "" {
+		fmt.Fprintf(a.stderr, "exported %d users to %s\n", job.Total, *out)
+	}
+	return nil
+}
+
+// waitForJob polls until the job finishes, printing progress to stderr.
+func (a *app) waitForJob(ctx context.Context, job Job) (Job, error) {
+	delay := 100 * time.Millisecond
+	for !job.finished() {
+		select {
+		case <-ctx.Done():
+			return job, ctx.Err()
+		case <-time.After(delay):
+		}
+		// Back off gently; big imports take a while.
+		delay = min(delay*2, 2*time.Second)
+
+		var err error
+		if job, err = a.client.getJob(ctx, job.ID); err != nil {
+			return job, err
+		}
+		if job.Total > 0 {
+			fmt.Fprintf(a.stderr, "\rjob %s: %s %d/%d", job.ID, job.Status, job.Done, job.Total)
+		}
+	}
+	if job.Total > 0 {
+		fmt.Fprintln(a.stderr)
+	}
+	return job, nil
+}
+
+// readImportFile reads users from a JSON array, NDJSON, or a CSV file with a
+// "name" column. CSV is picked by the .csv extension, JSON by a leading "[".
+func readImportFile(path string) ([]importUser, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return readImportCSV(data)
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var users []importUser
+		if err := json.Unmarshal(trimmed, &users); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", path, err)
+		}
+		return users, nil
+	}
+
+	var users []importUser
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 1<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var u importUser
+		if err := json.Unmarshal(sc.Bytes(), &u); err != nil {
+			return nil, fmt.Errorf("parse %s line %d: %w", path, line, err)
+		}
+		users = append(users, u)
+	}
+	return users, sc.Err()
+}
+
+func readImportCSV(data []byte) ([]importUser, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	col := slices.Index(records[0], "name")
+	if col < 0 {
+		return nil, errors.New(`CSV needs a "name" column`)
+	}
+	users := make([]importUser, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		users = append(users, importUser{Name: rec[col]})
+	}
+	return users, nil
+}
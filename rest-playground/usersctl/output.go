@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+)
+
+// printUsers writes users in the chosen output format.
+func printUsers(w io.Writer, format string, users []User) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(users)
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"id", "name", "email", "email_verified"})
+		for _, u := range users {
+			_ = cw.Write([]string{strconv.Itoa(u.ID), u.Name, u.Email, strconv.FormatBool(u.EmailVerified)})
+		}
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tNAME\tEMAIL\tVERIFIED")
+		for _, u := range users {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%v\n", u.ID, u.Name, u.Email, u.EmailVerified)
+		}
+		return tw.Flush()
+	}
+}
+
+// printJob writes a job in the chosen output format.
+func printJob(w io.Writer, format string, job Job) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(job)
+
+	case "csv":
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"id", "type", "status", "done", "total", "error"})
+		_ = cw.Write([]string{job.ID, job.Type, job.Status, strconv.Itoa(job.Done), strconv.Itoa(job.Total), job.Error})
+		cw.Flush()
+		return cw.Error()
+
+	default:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTYPE\tSTATUS\tPROGRESS\tERROR")
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d/%d\t%s\n", job.ID, job.Type, job.Status, job.Done, job.Total, job.Error)
+		return tw.Flush()
+	}
+}
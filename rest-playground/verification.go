@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// tokenPurpose keeps a verification token from being usable as a reset token and vice versa.
+type tokenPurpose string
+
+const (
+	purposeVerifyEmail   tokenPurpose = "verify-email"
+	purposeResetPassword tokenPurpose = "reset-password"
+
+	verifyTokenTTL = 24 * time.Hour
+	resetTokenTTL  = time.Hour
+
+	mailTimeout = 30 * time.Second
+
+	// maxTokenRequestBody is plenty for a token and a password.
+	maxTokenRequestBody = 64 << 10
+)
+
+var errInvalidToken = errors.New("invalid or expired token")
+
+type oneTimeToken struct {
+	purpose   tokenPurpose
+	userID    int
+	expiresAt time.Time
+}
+
+// tokenStore holds single-use tokens. Like sessions, only the SHA-256 of the
+// raw token is kept.
+type tokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]oneTimeToken
+}
+
+func newTokenStore() *tokenStore {
+	return &tokenStore{tokens: make(map[string]oneTimeToken)}
+}
+
+// issue creates a new token and invalidates any older token with the same
+// purpose for the same user, so only the most recent email works.
+func (s *tokenStore) issue(purpose tokenPurpose, userID int, ttl time.Duration) (string, error) {
+	raw, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, t := range s.tokens {
+		if t.purpose == purpose && t.userID == userID {
+			delete(s.tokens, key)
+		}
+	}
+	s.tokens[hashToken(raw)] = oneTimeToken{
+		purpose:   purpose,
+		userID:    userID,
+		expiresAt: time.Now().Add(ttl),
+	}
+	return raw, nil
+}
+
+// consume redeems a token. It is deleted whether or not it has expired,
+// so it can never be used twice.
+func (s *tokenStore) consume(purpose tokenPurpose, raw string) (int, error) {
+	key := hashToken(raw)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.tokens[key]
+	if !ok || t.purpose != purpose {
+		return 0, errInvalidToken
+	}
+	delete(s.tokens, key)
+	if time.Now().After(t.expiresAt) {
+		return 0, errInvalidToken
+	}
+	return t.userID, nil
+}
+
+// purgeExpired drops tokens that can no longer be redeemed.
+func (s *tokenStore) purgeExpired(now time.Time) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := 0
+	for key, t := range s.tokens {
+		if now.After(t.expiresAt) {
+			delete(s.tokens, key)
+			n++
+		}
+	}
+	return n
+}
+
+// userIDForEmail returns the user registered with the email, if any.
+func (a *authService) userIDForEmail(email string) (int, bool) {
+	a.mu.Lock()
+	c, ok := a.credentials[normalizeEmail(email)]
+	a.mu.Unlock()
+	if !ok {
+		return 0, false
+	}
+	if _, err := a.store.getUser(c.userID); err != nil {
+		return 0, false
+	}
+	return c.userID, true
+}
+
+// setPassword replaces the password hash for a user and ends their sessions.
+func (a *authService) setPassword(userID int, password string) error {
+	u, err := a.store.getUser(userID)
+	if err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	email := normalizeEmail(u.Email)
+
+	a.mu.Lock()
+	a.credentials[email] = credential{userID: userID, passwordHash: hash}
+	// Whoever reset the password owns the account now: drop the lockout
+	// and everybody who was logged in with the old password.
+	delete(a.failures, email)
+	a.mu.Unlock()
+
+	a.revokeUser(userID)
+	return nil
+}
+
+// sendVerification emails a fresh verification link to the user.
+// It runs in the background so callers answer in the same time whether or not
+// an email was sent.
+func (a *authService) sendVerification(u User) {
+	a.sendTokenMail(u, purposeVerifyEmail, verifyTokenTTL, "Verify your email",
+		"Confirm your email address by opening the link below and pressing the button within 24 hours:\n\n%s\n",
+		"/auth/verify")
+}
+
+// sendPasswordReset emails a password reset link to the user, in the background.
+func (a *authService) sendPasswordReset(u User) {
+	a.sendTokenMail(u, purposeResetPassword, resetTokenTTL, "Reset your password",
+		"Someone asked to reset your password. If it was you, open the link below within an hour and choose a new one:\n\n%s\n\nOtherwise you can ignore this email.\n",
+		"/auth/password/reset")
+}
+
+func (a *authService) sendTokenMail(u User, purpose tokenPurpose, ttl time.Duration, subject, bodyFormat, path string) {
+	token, err := a.tokens.issue(purpose, u.ID, ttl)
+	if err != nil {
+		log.Printf("issue %s token for user %d: %v", purpose, u.ID, err)
+		return
+	}
+
+	link := a.publicURL + path + "?tenant=" + url.QueryEscape(a.tenantID) + "&token=" + url.QueryEscape(token)
+	msg := Message{
+		To:      u.Email,
+		Subject: subject,
+		Body:    fmt.Sprintf(bodyFormat, link),
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), mailTimeout)
+		defer cancel()
+		if err := a.mailer.Send(ctx, msg); err != nil {
+			log.Printf("send %s mail to user %d: %v", purpose, u.ID, err)
+		}
+	}()
+}
+
+//go:embed templates/auth/*.html
+var authTemplatesFS embed.FS
+
+// authPages are the pages behind the emailed links. Opening a link only shows
+// a form; the token is redeemed when the form is posted, so mail scanners
+// that prefetch links don't use it up.
+var authPages = func() map[string]*template.Template {
+	pages := make(map[string]*template.Template)
+	for _, name := range []string{"verify.html", "reset.html", "done.html"} {
+		pages[name] = template.Must(template.ParseFS(authTemplatesFS, "templates/auth/layout.html", "templates/auth/"+name))
+	}
+	return pages
+}()
+
+type authPageData struct {
+	Title   string
+	Tenant  string
+	Token   string
+	Error   string
+	Message string
+}
+
+func renderAuthPage(w http.ResponseWriter, r *http.Request, status int, name string, data authPageData) {
+	data.Tenant = tenantFrom(r.Context()).id
+	h := w.Header()
+	// The token is in the URL: keep it out of Referer headers and caches.
+	h.Set("Referrer-Policy", "no-referrer")
+	h.Set("Cache-Control", "no-store")
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := authPages[name].ExecuteTemplate(w, "layout", data); err != nil {
+		log.Printf("render %s: %v", name, err)
+	}
+}
+
+func registerVerificationRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /auth/verify", handleVerifyForm)
+	mux.HandleFunc("POST /auth/verify", tenantAuth((*authService).handleVerify))
+	mux.HandleFunc("POST /auth/verify/resend", tenantAuth((*authService).handleResendVerification))
+	mux.HandleFunc("POST /auth/password/forgot", tenantAuth((*authService).handleForgotPassword))
+	mux.HandleFunc("GET /auth/password/reset", handleResetForm)
+	mux.HandleFunc("POST /auth/password/reset", tenantAuth((*authService).handleResetPassword))
+}
+
+type emailRequest struct {
+	Email string `json:"email"`
+}
+
+type tokenRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password,omitempty"`
+}
+
+// decodeTokenRequest reads a JSON body from API clients or a form posted
+// from one of authPages. form tells the handler to answer with a page.
+// A body is only taken as a form if it has a token field: curl -d sends JSON
+// labelled as a form unless told otherwise.
+func decodeTokenRequest(r *http.Request) (req tokenRequest, form bool, err error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxTokenRequestBody))
+	if err != nil {
+		return req, false, err
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		if v, err := url.ParseQuery(string(body)); err == nil && v.Has("token") {
+			req.Token = v.Get("token")
+			req.Password = v.Get("password")
+			return req, true, nil
+		}
+	}
+	err = json.Unmarshal(body, &req)
+	return req, false, err
+}
+
+// handleVerifyForm is where the verification email links to.
+func handleVerifyForm(w http.ResponseWriter, r *http.Request) {
+	renderAuthPage(w, r, http.StatusOK, "verify.html", authPageData{
+		Title: "Verify your email",
+		Token: r.URL.Query().Get("token"),
+	})
+}
+
+func (a *authService) handleVerify(w http.ResponseWriter, r *http.Request) {
+	req, form, err := decodeTokenRequest(r)
+	if err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := a.tokens.consume(purposeVerifyEmail, req.Token)
+	if err == nil {
+		err = a.store.markEmailVerified(userID)
+	}
+	switch {
+	case err != nil && form:
+		renderAuthPage(w, r, http.StatusBadRequest, "done.html", authPageData{
+			Title: "Verify your email",
+			Error: "This link is invalid or has expired. Ask for a new verification email.",
+		})
+	case err != nil:
+		http.Error(w, errInvalidToken.Error(), http.StatusBadRequest)
+	case form:
+		renderAuthPage(w, r, http.StatusOK, "done.html", authPageData{
+			Title:   "Verify your email",
+			Message: "Your email address is verified.",
+		})
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleResendVerification always answers 202, so it can't be used to find out
+// which emails have accounts.
+func (a *authService) handleResendVerification(w http.ResponseWriter, r *http.Request) {
+	var req emailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if userID, ok := a.userIDForEmail(req.Email); ok {
+		if u, err := a.store.getUser(userID); err == nil && !u.EmailVerified {
+			a.sendVerification(u)
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleForgotPassword always answers 202 for the same reason as handleResendVerification.
+func (a *authService) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req emailRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+
+	if userID, ok := a.userIDForEmail(req.Email); ok {
+		if u, err := a.store.getUser(userID); err == nil {
+			a.sendPasswordReset(u)
+		}
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleResetForm is where the password reset email links to.
+func handleResetForm(w http.ResponseWriter, r *http.Request) {
+	renderAuthPage(w, r, http.StatusOK, "reset.html", authPageData{
+		Title: "Choose a new password",
+		Token: r.URL.Query().Get("token"),
+	})
+}
+
+func (a *authService) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	req, form, err := decodeTokenRequest(r)
+	if err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	// Validate before consuming, so a typo in the new password doesn't burn the token.
+	if msg := checkPassword(req.Password); msg != "" {
+		if form {
+			renderAuthPage(w, r, http.StatusBadRequest, "reset.html", authPageData{
+				Title: "Choose a new password",
+				Token: req.Token,
+				Error: strings.ToUpper(msg[:1]) + msg[1:] + ".",
+			})
+			return
+		}
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	userID, err := a.tokens.consume(purposeResetPassword, req.Token)
+	if err == nil {
+		err = a.setPassword(userID, req.Password)
+	}
+	switch {
+	case err != nil && form:
+		renderAuthPage(w, r, http.StatusBadRequest, "done.html", authPageData{
+			Title: "Choose a new password",
+			Error: "This link is invalid or has expired. Ask for a new password reset email.",
+		})
+	case err != nil:
+		http.Error(w, errInvalidToken.Error(), http.StatusBadRequest)
+	case form:
+		renderAuthPage(w, r, http.StatusOK, "done.html", authPageData{
+			Title:   "Choose a new password",
+			Message: "Your password has been changed. You can sign in with it now.",
+		})
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
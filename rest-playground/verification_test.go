@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureMailer hands every message to the test. Mail goes out in the
+// background, so tests wait for it on the channel.
+type captureMailer chan Message
+
+func (m captureMailer) Send(_ context.Context, msg Message) error {
+	m <- msg
+	return nil
+}
+
+var mailLink = regexp.MustCompile(`https?://\S+`)
+
+// nextLink waits for the next message and returns the link in it.
+func (m captureMailer) nextLink(t *testing.T, to string) *url.URL {
+	t.Helper()
+	select {
+	case msg := <-m:
+		if msg.To != to {
+			t.Fatalf("mail to %q, want %q", msg.To, to)
+		}
+		link, err := url.Parse(mailLink.FindString(msg.Body))
+		if err != nil || link.Query().Get("token") == "" {
+			t.Fatalf("no link with a token in %q", msg.Body)
+		}
+		return link
+	case <-time.After(5 * time.Second):
+		t.Fatal("no mail sent")
+		return nil
+	}
+}
+
+type verificationTest struct {
+	mail captureMailer
+	reg  *tenantRegistry
+	h    http.Handler
+}
+
+func newVerificationTest() *verificationTest {
+	mail := make(captureMailer, 10)
+	reg := newTenantRegistry(mail, "http://example.test", nil, 10, tenantQuota{}, nil)
+	return &verificationTest{mail: mail, reg: reg, h: newTenantTestServer(reg)}
+}
+
+// register creates an account in acme and returns its ID and the link from
+// the verification email.
+func (vt *verificationTest) register(t *testing.T, email, password string) (int, *url.URL) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"name": "Ana", "email": email, "password": password})
+	w := tenantRequest(t, vt.h, "POST", "/auth/register", "acme", "", string(body))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register: status %d: %s", w.Code, w.Body)
+	}
+	var u User
+	if err := json.Unmarshal(w.Body.Bytes(), &u); err != nil {
+		t.Fatal(err)
+	}
+	return u.ID, vt.mail.nextLink(t, email)
+}
+
+// redeem posts token to path as an API client would.
+func (vt *verificationTest) redeem(t *testing.T, path, token, password string) int {
+	t.Helper()
+	body, _ := json.Marshal(tokenRequest{Token: token, Password: password})
+	return tenantRequest(t, vt.h, "POST", path, "acme", "", string(body)).Code
+}
+
+func (vt *verificationTest) user(t *testing.T, id int) User {
+	t.Helper()
+	w := tenantRequest(t, vt.h, "GET", "/users/"+strconv.Itoa(id), "acme", "", "")
+	var u User
+	if err := json.Unmarshal(w.Body.Bytes(), &u); err != nil {
+		t.Fatalf("get user %d: status %d: %s", id, w.Code, w.Body)
+	}
+	return u
+}
+
+func (vt *verificationTest) login(t *testing.T, email, password string) int {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"email": email, "password": password})
+	return tenantRequest(t, vt.h, "POST", "/auth/login", "acme", "", string(body)).Code
+}
+
+// expireTokens makes every one-time token in acme expire.
+func (vt *verificationTest) expireTokens(t *testing.T) {
+	t.Helper()
+	tn, err := vt.reg.get("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := tn.auth.tokens
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, tok := range s.tokens {
+		tok.expiresAt = time.Now().Add(-time.Second)
+		s.tokens[key] = tok
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	vt := newVerificationTest()
+	id, link := vt.register(t, "ana@example.com", "correct-horse")
+
+	if link.Path != "/auth/verify" || link.Query().Get("tenant") != "acme" {
+		t.Errorf("link %s, want /auth/verify for tenant acme", link)
+	}
+	if vt.user(t, id).EmailVerified {
+		t.Fatal("verified before the link was used")
+	}
+
+	// Opening the link only shows the form.
+	token := link.Query().Get("token")
+	w := tenantRequest(t, vt.h, "GET", link.RequestURI(), "", "", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), token) {
+		t.Errorf("verify page: status %d, token in form: %v", w.Code, strings.Contains(w.Body.String(), token))
+	}
+	if vt.user(t, id).EmailVerified {
+		t.Error("opening the link verified the email")
+	}
+
+	if code := vt.redeem(t, "/auth/verify", token, ""); code != http.StatusNoContent {
+		t.Fatalf("verify: status %d, want 204", code)
+	}
+	if !vt.user(t, id).EmailVerified {
+		t.Error("not verified after redeeming the token")
+	}
+	if code := vt.redeem(t, "/auth/verify", token, ""); code != http.StatusBadRequest {
+		t.Errorf("token used twice: status %d, want 400", code)
+	}
+}
+
+func TestVerifyEmailFromForm(t *testing.T) {
+	vt := newVerificationTest()
+	id, link := vt.register(t, "ana@example.com", "correct-horse")
+
+	// Posted as the page's form does; tenantRequest would label it JSON.
+	form := url.Values{"token": {link.Query().Get("token")}}.Encode()
+	post := func() (int, string) {
+		r := httptest.NewRequest("POST", "/auth/verify?tenant=acme", strings.NewReader(form))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		vt.h.ServeHTTP(w, r)
+		return w.Code, w.Header().Get("Content-Type")
+	}
+
+	if code, ct := post(); code != http.StatusOK || !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("form post: status %d, Content-Type %q, want a 200 page", code, ct)
+	}
+	if !vt.user(t, id).EmailVerified {
+		t.Error("not verified after posting the form")
+	}
+	if code, _ := post(); code != http.StatusBadRequest {
+		t.Errorf("form posted twice: status %d, want 400", code)
+	}
+}
+
+func TestVerifyRejectsBadTokens(t *testing.T) {
+	vt := newVerificationTest()
+	id, first := vt.register(t, "ana@example.com", "correct-horse")
+
+	if code := vt.redeem(t, "/auth/verify", "no-such-token", ""); code != http.StatusBadRequest {
+		t.Errorf("unknown token: status %d, want 400", code)
+	}
+
+	// Asking again replaces the first token.
+	if w := tenantRequest(t, vt.h, "POST", "/auth/verify/resend", "acme", "", `{"email": "ana@example.com"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("resend: status %d", w.Code)
+	}
+	second := vt.mail.nextLink(t, "ana@example.com")
+	if code := vt.redeem(t, "/auth/verify", first.Query().Get("token"), ""); code != http.StatusBadRequest {
+		t.Errorf("replaced token: status %d, want 400", code)
+	}
+
+	// A verification token can't reset the password.
+	if code := vt.redeem(t, "/auth/password/reset", second.Query().Get("token"), "new-password-1"); code != http.StatusBadRequest {
+		t.Errorf("verification token used for a reset: status %d, want 400", code)
+	}
+
+	vt.expireTokens(t)
+	if code := vt.redeem(t, "/auth/verify", second.Query().Get("token"), ""); code != http.StatusBadRequest {
+		t.Errorf("expired token: status %d, want 400", code)
+	}
+	if vt.user(t, id).EmailVerified {
+		t.Error("verified by a bad token")
+	}
+}
+
+func TestPasswordReset(t *testing.T) {
+	vt := newVerificationTest()
+	vt.register(t, "ana@example.com", "correct-horse")
+
+	if w := tenantRequest(t, vt.h, "POST", "/auth/password/forgot", "acme", "", `{"email": "ana@example.com"}`); w.Code != http.StatusAccepted {
+		t.Fatalf("forgot: status %d", w.Code)
+	}
+	link := vt.mail.nextLink(t, "ana@example.com")
+	if link.Path != "/auth/password/reset" {
+		t.Errorf("link %s, want /auth/password/reset", link)
+	}
+	token := link.Query().Get("token")
+
+	// A password that fails the checks leaves the token usable.
+	if code := vt.redeem(t, "/auth/password/reset", token, "short"); code != http.StatusBadRequest {
+		t.Errorf("short password: status %d, want 400", code)
+	}
+	if code := vt.redeem(t, "/auth/password/reset", token, "battery-staple"); code != http.StatusNoContent {
+		t.Fatalf("reset: status %d, want 204", code)
+	}
+	if code := vt.redeem(t, "/auth/password/reset", token, "another-one-1"); code != http.StatusBadRequest {
+		t.Errorf("token used twice: status %d, want 400", code)
+	}
+
+	if code := vt.login(t, "ana@example.com", "correct-horse"); code != http.StatusUnauthorized {
+		t.Errorf("login with the old password: status %d, want 401", code)
+	}
+	if code := vt.login(t, "ana@example.com", "battery-staple"); code != http.StatusOK {
+		t.Errorf("login with the new password: status %d, want 200", code)
+	}
+}
+
+func TestPasswordResetExpires(t *testing.T) {
+	vt := newVerificationTest()
+	vt.register(t, "ana@example.com", "correct-horse")
+
+	tenantRequest(t, vt.h, "POST", "/auth/password/forgot", "acme", "", `{"email": "ana@example.com"}`)
+	link := vt.mail.nextLink(t, "ana@example.com")
+	vt.expireTokens(t)
+
+	if code := vt.redeem(t, "/auth/password/reset", link.Query().Get("token"), "battery-staple"); code != http.StatusBadRequest {
+		t.Errorf("expired token: status %d, want 400", code)
+	}
+	if code := vt.login(t, "ana@example.com", "correct-horse"); code != http.StatusOK {
+		t.Errorf("login with the old password: status %d, want 200", code)
+	}
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	vt := newVerificationTest()
+	if w := tenantRequest(t, vt.h, "POST", "/auth/password/forgot", "acme", "", `{"email": "nobody@example.com"}`); w.Code != http.StatusAccepted {
+		t.Errorf("unknown email: status %d, want 202 like any other", w.Code)
+	}
+	select {
+	case msg := <-vt.mail:
+		t.Errorf("mail sent for an unknown email: %+v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}